@@ -4,62 +4,614 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Informo/specs-bot/types"
 
 	"gopkg.in/yaml.v2"
 )
 
+// Default values for the optional settings.
+const (
+	defaultMatrixSendAttempts = 3
+	defaultMatrixSendBackoff  = time.Second
+	defaultDeliveryRetention  = 30 * 24 * time.Hour
+	defaultQueueWorkers       = 1
+	defaultQueueMaxAttempts   = 5
+	defaultQueueBackoff       = 10 * time.Second
+	defaultQueuePollInterval  = time.Second
+	defaultReportsStuckLimit  = 10
+	defaultLabelSeparator     = ":"
+	defaultLabelTypePrefix    = "type"
+	defaultLabelStatePrefix   = "scsp"
+	defaultReminderInterval   = time.Minute
+	defaultConflictPattern    = "Conflicting {{ .Kind }} labels on proposal #{{ .Number }} \"{{ .Title }}\" ({{ .Repository }}): {{ .Labels }}{{ if .Resolution }}, using {{ .Resolution }}{{ end }}: {{ .URL }}"
+	defaultTransitionPattern  = "Invalid SCSP transition for {{ .Type }} proposal #{{ .Number }} \"{{ .Title }}\" ({{ .Repository }}) from \"{{ .From }}\" to \"{{ .To }}\" by {{ .Actor }}: {{ .URL }}"
+)
+
 var supportedDBDrivers = map[string]bool{
 	"postgres": true,
 	"sqlite3":  true,
 }
 
+// Policies for handling conflicting labels on a proposal.
+const (
+	// ConflictPolicyReport makes the bot report the conflict without sending
+	// any notice for the update.
+	ConflictPolicyReport = "report"
+	// ConflictPolicyLatest makes the bot report the conflict and resolve it by
+	// picking the most recently added label, if it's the one added by the
+	// event being processed.
+	ConflictPolicyLatest = "latest"
+)
+
+// Policies for handling events the webhook doesn't process.
+const (
+	// UnknownEventsReject makes the webhook respond with a 400 status code.
+	UnknownEventsReject = "reject"
+	// UnknownEventsAccept makes the webhook ignore the event and respond with a
+	// 202 status code.
+	UnknownEventsAccept = "accept"
+)
+
 var (
 	// ErrUnsupportedDBDriver is returned if the driver name in the configuration
 	// file doesn't refer to a supported database driver.
 	ErrUnsupportedDBDriver = fmt.Errorf("Unsupported database driver, only \"postgres\" and \"sqlite3\" are supported")
+	// ErrUnsupportedUnknownEventsPolicy is returned if the policy for unknown
+	// events in the configuration file isn't a supported one.
+	ErrUnsupportedUnknownEventsPolicy = fmt.Errorf("Unsupported policy for unknown events, only \"reject\" and \"accept\" are supported")
+	// ErrUnsupportedConflictPolicy is returned if the policy for conflicting
+	// labels in the configuration file isn't a supported one.
+	ErrUnsupportedConflictPolicy = fmt.Errorf("Unsupported policy for conflicting labels, only \"report\" and \"latest\" are supported")
+	// ErrInvalidNoticeRule is returned if a notice rule in the configuration
+	// file doesn't define any message, or defines a message that isn't
+	// formatted as "section/name".
+	ErrInvalidNoticeRule = fmt.Errorf("Invalid notice rule, at least one message formatted as \"section/name\" must be defined")
+	// ErrInvalidReminder is returned if a reminder in the configuration file
+	// doesn't define a type, a SCSP state and a positive delay.
+	ErrInvalidReminder = fmt.Errorf("Invalid reminder, a type, a state and a positive delay must be defined")
 )
 
 // Config represents the top-level structure of the configuration file.
 type Config struct {
-	Matrix   MatrixConfig   `yaml:"matrix"`
-	Webhook  WebhookConfig  `yaml:"webhook"`
-	Notices  NoticesConfig  `yaml:"notices"`
-	Database DatabaseConfig `yaml:"database"`
+	Matrix       MatrixConfig       `yaml:"matrix"`
+	Webhook      WebhookConfig      `yaml:"webhook"`
+	Notices      NoticesConfig      `yaml:"notices"`
+	Repositories []RepositoryConfig `yaml:"repositories"`
+	Comments     CommentsConfig     `yaml:"comments"`
+	Queue        QueueConfig        `yaml:"queue"`
+	Reports      ReportsConfig      `yaml:"reports"`
+	Reminders    RemindersConfig    `yaml:"reminders"`
+	Transitions  TransitionsConfig  `yaml:"transitions"`
+	Conflicts    ConflictsConfig    `yaml:"conflicts"`
+	Database     DatabaseConfig     `yaml:"database"`
 }
 
 // MatrixConfig represents the Matrix part of the configuration file.
 type MatrixConfig struct {
-	HSURL       string `yaml:"hs_url"`
-	MXID        string `yaml:"mxid"`
-	AccessToken string `yaml:"access_token"`
+	HSURL        string        `yaml:"hs_url"`
+	MXID         string        `yaml:"mxid"`
+	AccessToken  string        `yaml:"access_token"`
+	SendAttempts int           `yaml:"send_attempts"`
+	SendBackoff  time.Duration `yaml:"send_backoff"`
 }
 
 // WebhookConfig represents the webhook part of the configuration file.
 type WebhookConfig struct {
-	Path       string `yaml:"path"`
-	Secret     string `yaml:"secret"`
-	ListenAddr string `yaml:"listen_addr"`
+	Path              string        `yaml:"path"`
+	Secret            string        `yaml:"secret"`
+	ListenAddr        string        `yaml:"listen_addr"`
+	UnknownEvents     string        `yaml:"unknown_events"`
+	DeliveryRetention time.Duration `yaml:"delivery_retention"`
 }
 
 // NoticesConfig represents the notices part of the configurations file. It
 // also contains a map of strings that will be filled from the strings JSON
 // file.
 type NoticesConfig struct {
-	Pattern         string   `yaml:"pattern"`
+	Pattern         string       `yaml:"pattern"`
+	HTMLPattern     string       `yaml:"html_pattern"`
+	Rooms           []RoomConfig `yaml:"rooms"`
+	StringsFilePath string       `yaml:"strings_file"`
+	Threads         bool         `yaml:"threads"`
+	Labels          LabelGrammar `yaml:"labels"`
+	Rules           []NoticeRule `yaml:"rules"`
+	Strings         map[string]map[string]string
+}
+
+// RepositoryConfig represents the configuration of a single GitHub repository
+// the bot sends notices for, identified by its full name (e.g.
+// "Informo/specs"). A repository with an empty name matches every repository
+// that isn't explicitly configured.
+// Its secret defaults to the one defined in the webhook part of the
+// configuration file, and its pattern, HTML pattern, rooms, strings, label
+// grammar and notice rules default to the ones defined in the notices part of
+// the configuration file.
+type RepositoryConfig struct {
+	Name            string        `yaml:"name"`
+	Secret          string        `yaml:"secret"`
+	Pattern         string        `yaml:"pattern"`
+	HTMLPattern     string        `yaml:"html_pattern"`
+	Rooms           []RoomConfig  `yaml:"rooms"`
+	StringsFilePath string        `yaml:"strings_file"`
+	Labels          *LabelGrammar `yaml:"labels"`
+	Rules           []NoticeRule  `yaml:"rules"`
+	Strings         map[string]map[string]string
+}
+
+// NoticeRooms returns the rooms the notices selected by the given rule must be
+// sent to, i.e. the repository's rooms which IDs are listed by the rule, or
+// all of the repository's rooms if the rule doesn't list any. Listed rooms
+// that aren't configured for the repository use its pattern, HTML pattern and
+// strings.
+func (r *RepositoryConfig) NoticeRooms(rule *NoticeRule) []RoomConfig {
+	if len(rule.Rooms) == 0 {
+		return r.Rooms
+	}
+
+	rooms := make([]RoomConfig, 0, len(rule.Rooms))
+	for _, id := range rule.Rooms {
+		room := RoomConfig{
+			ID:          id,
+			Pattern:     r.Pattern,
+			HTMLPattern: r.HTMLPattern,
+			Strings:     r.Strings,
+		}
+
+		for _, configured := range r.Rooms {
+			if configured.ID == id {
+				room = configured
+				break
+			}
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+// NoticeRule represents a rule deciding whether and how a notice is sent for
+// an update to a proposal. An update matches the rule if it matches every
+// non-empty setting in it, i.e. if the GitHub event is one of Events, its
+// action is one of Actions, the proposal carries at least one of Labels, the
+// label added by the event is one of Added, the label removed by the event is
+// one of Removed, the proposal's type is one of Types, its SCSP state is one
+// of States, its author is one of Authors, its draft status is Draft and
+// whether its type and SCSP state could both be determined from its labels is
+// SCSP.
+// Messages lists the message strings the notice can use, as Go patterns
+// generating their location in the strings file formatted as "section/name",
+// ordered by preference. Rooms lists the IDs of the rooms to send the notice
+// to, which defaults to the repository's rooms. If CheckTransition is true,
+// the notice isn't sent if the proposal's transition from its previous SCSP
+// state isn't allowed by the configured state machine. If EditPrevious is true,
+// the notice announces an update to the proposal's state, and is sent as an
+// edit of the previous such notice in the rooms configured to edit previous
+// notices.
+type NoticeRule struct {
+	Name            string   `yaml:"name"`
+	Events          []string `yaml:"events"`
+	Actions         []string `yaml:"actions"`
+	Labels          []string `yaml:"labels"`
+	Added           []string `yaml:"added"`
+	Removed         []string `yaml:"removed"`
+	Types           []string `yaml:"types"`
+	States          []string `yaml:"states"`
+	Authors         []string `yaml:"authors"`
+	Draft           *bool    `yaml:"draft"`
+	SCSP            *bool    `yaml:"scsp"`
+	CheckTransition bool     `yaml:"check_transition"`
+	EditPrevious    bool     `yaml:"edit_previous"`
+	Messages        []string `yaml:"messages"`
 	Rooms           []string `yaml:"rooms"`
-	StringsFilePath string   `yaml:"strings_file"`
+
+	messageTemplates []*template.Template
+}
+
+// MessageTemplates returns the templates parsed from the rule's messages, in
+// the same order.
+func (r *NoticeRule) MessageTemplates() []*template.Template {
+	return r.messageTemplates
+}
+
+// defaultNoticeRules returns the notice rules implementing the bot's default
+// behaviour, which follows the Informo SCSP: announcing the removal of labels
+// which have a message string in the "unlabeled" section of the strings file,
+// the proposals' SCSP states, the labels that aren't related to the Informo
+// SCSP added to proposals that don't implement it, pull requests' lifecycle
+// events (falling back to the "global" section of the strings file, so merged
+// pull requests are announced even without a "scsp:merged" label), and
+// reviews.
+func defaultNoticeRules() []NoticeRule {
+	scsp, notSCSP := true, false
+
+	return []NoticeRule{
+		{
+			Name:         "unlabeled",
+			Events:       []string{"pull_request", "issues"},
+			Actions:      []string{"unlabeled"},
+			EditPrevious: true,
+			Messages:     []string{"unlabeled/{{ .Removed }}"},
+		},
+		{
+			Name:            "scsp",
+			Events:          []string{"pull_request", "issues"},
+			Actions:         []string{"labeled", "unlabeled"},
+			SCSP:            &scsp,
+			CheckTransition: true,
+			EditPrevious:    true,
+			Messages: []string{
+				"{{ .Type }}/{{ .State }}",
+				"global/{{ .State }}",
+			},
+		},
+		{
+			Name:         "generic",
+			Events:       []string{"pull_request", "issues"},
+			Actions:      []string{"labeled"},
+			SCSP:         &notSCSP,
+			EditPrevious: true,
+			Messages:     []string{"global/{{ .Added }}"},
+		},
+		{
+			Name:   "pull_request",
+			Events: []string{"pull_request"},
+			Actions: []string{
+				"opened", "closed", "merged", "reopened", "ready_for_review",
+				"converted_to_draft",
+			},
+			Messages: []string{
+				"pull_request/{{ .Action }}",
+				"global/{{ .Action }}",
+			},
+		},
+		{
+			Name:     "review",
+			Events:   []string{"pull_request_review"},
+			Messages: []string{"review/{{ .Action }}"},
+		},
+	}
+}
+
+// checkNoticeRules checks that each of the given notice rules defines at least
+// one message, and that each of its messages is formatted as "section/name",
+// then parses its messages' templates.
+// Returns ErrInvalidNoticeRule if one of the rules is invalid.
+// Returns an error if one of the messages' templates couldn't be parsed.
+func checkNoticeRules(rules []NoticeRule) error {
+	for i := range rules {
+		rule := &(rules[i])
+		if len(rule.Messages) == 0 {
+			return ErrInvalidNoticeRule
+		}
+
+		rule.messageTemplates = make([]*template.Template, 0, len(rule.Messages))
+		for _, msg := range rule.Messages {
+			if !strings.Contains(msg, "/") {
+				return ErrInvalidNoticeRule
+			}
+
+			tmpl, err := template.New("key").Parse(msg)
+			if err != nil {
+				return fmt.Errorf(
+					"Invalid message %q in notice rule %q: %v", msg, rule.Name, err,
+				)
+			}
+			rule.messageTemplates = append(rule.messageTemplates, tmpl)
+		}
+	}
+
+	return nil
+}
+
+// Kinds of information a label name can hold about a proposal.
+const (
+	// LabelKindType is the kind of the labels defining a proposal's type.
+	LabelKindType = "type"
+	// LabelKindState is the kind of the labels defining a proposal's SCSP
+	// state.
+	LabelKindState = "state"
+)
+
+// LabelGrammar represents the grammar of the label names defining a proposal's
+// type and SCSP state. By default, such a label name is made of a prefix
+// ("type" for the type, "scsp" for the SCSP state) and a value, separated by a
+// separator (":"), e.g. "scsp:review". If a regular expression is defined for
+// the type or the SCSP state, it is used instead of the prefix and separator,
+// and the value is the regular expression's first capturing group, or the
+// whole match if it doesn't have any.
+type LabelGrammar struct {
+	Separator   string `yaml:"separator"`
+	TypePrefix  string `yaml:"type_prefix"`
+	StatePrefix string `yaml:"state_prefix"`
+	TypeRegex   string `yaml:"type_regex"`
+	StateRegex  string `yaml:"state_regex"`
+
+	typeRegexp  *regexp.Regexp
+	stateRegexp *regexp.Regexp
+}
+
+// Match extracts the kind of information (LabelKindType or LabelKindState) held
+// by the given label name, and its value.
+// Returns an empty kind if the label name doesn't define the proposal's type
+// or SCSP state.
+func (g *LabelGrammar) Match(label string) (kind string, value string) {
+	if value, ok := g.match(label, g.typeRegexp, g.TypePrefix); ok {
+		return LabelKindType, value
+	}
+
+	if value, ok := g.match(label, g.stateRegexp, g.StatePrefix); ok {
+		return LabelKindState, value
+	}
+
+	return "", ""
+}
+
+// match extracts the value from the given label name using the given regular
+// expression if it's not nil, or the given prefix and the grammar's separator
+// otherwise.
+// Returns false if the label name doesn't match.
+func (g *LabelGrammar) match(
+	label string, re *regexp.Regexp, prefix string,
+) (string, bool) {
+	if re != nil {
+		submatches := re.FindStringSubmatch(label)
+		if submatches == nil {
+			return "", false
+		}
+		if len(submatches) > 1 {
+			return submatches[1], true
+		}
+		return submatches[0], true
+	}
+
+	// Only split on the first separator, so the value can contain the
+	// separator itself.
+	split := strings.SplitN(label, g.Separator, 2)
+	if len(split) < 2 || split[0] != prefix {
+		return "", false
+	}
+
+	return split[1], true
+}
+
+// compile fills the grammar's settings that haven't been defined with their
+// default values, and compiles its regular expressions.
+// Returns an error if a regular expression couldn't be compiled.
+func (g *LabelGrammar) compile() (err error) {
+	if len(g.Separator) == 0 {
+		g.Separator = defaultLabelSeparator
+	}
+
+	if len(g.TypePrefix) == 0 {
+		g.TypePrefix = defaultLabelTypePrefix
+	}
+
+	if len(g.StatePrefix) == 0 {
+		g.StatePrefix = defaultLabelStatePrefix
+	}
+
+	if len(g.TypeRegex) > 0 {
+		if g.typeRegexp, err = regexp.Compile(g.TypeRegex); err != nil {
+			return
+		}
+	}
+
+	if len(g.StateRegex) > 0 {
+		if g.stateRegexp, err = regexp.Compile(g.StateRegex); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// RoomConfig represents the configuration of a single Matrix room to send
+// notices to. It can be defined in the configuration file either as a mapping
+// or as a plain string containing the room's ID.
+// Its pattern, HTML pattern and strings default to the ones of the repository
+// it's configured for.
+type RoomConfig struct {
+	ID              string         `yaml:"id"`
+	EditPrevious    bool           `yaml:"edit_previous"`
+	Filters         []NoticeFilter `yaml:"filters"`
+	Pattern         string         `yaml:"pattern"`
+	HTMLPattern     string         `yaml:"html_pattern"`
+	StringsFilePath string         `yaml:"strings_file"`
 	Strings         map[string]map[string]string
 }
 
+// NoticeFilter represents a filter on the notices to send to a room. A notice
+// matches the filter if it matches every non-empty list in it, i.e. if the
+// proposal's type is one of Types, its SCSP state is one of States and it
+// carries at least one of Labels.
+type NoticeFilter struct {
+	Types  []string `yaml:"types"`
+	States []string `yaml:"states"`
+	Labels []string `yaml:"labels"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. It allows a room to be defined by
+// its ID only, in which case every other setting is left to its default value.
+func (r *RoomConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&r.ID); err == nil {
+		return nil
+	}
+
+	// Use an alias type so that unmarshalling the mapping doesn't call this
+	// function again.
+	type rawRoomConfig RoomConfig
+	return unmarshal((*rawRoomConfig)(r))
+}
+
+// CommentsConfig represents the comments part of the configuration file, which
+// defines whether and how comments on proposals are relayed to Matrix rooms.
+type CommentsConfig struct {
+	Rooms              []string `yaml:"rooms"`
+	Pattern            string   `yaml:"pattern"`
+	Threads            bool     `yaml:"threads"`
+	AuthorAssociations []string `yaml:"author_associations"`
+	MinLength          int      `yaml:"min_length"`
+	OptInLabel         string   `yaml:"opt_in_label"`
+}
+
+// QueueConfig represents the queue part of the configuration file, which
+// defines how webhook payloads are processed.
+type QueueConfig struct {
+	Workers      int           `yaml:"workers"`
+	MaxAttempts  int           `yaml:"max_attempts"`
+	Backoff      time.Duration `yaml:"backoff"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// ReportsConfig represents the reports part of the configuration file, which
+// defines how the reports on the time proposals spend in each SCSP state are
+// served. The HTTP endpoint serving them is disabled if Path is empty.
+// StuckLimit is the number of proposals listed as stuck by default.
+type ReportsConfig struct {
+	Path       string `yaml:"path"`
+	StuckLimit int    `yaml:"stuck_limit"`
+}
+
+// RemindersConfig represents the reminders part of the configuration file,
+// which defines the reminders to send once a proposal has stayed in a given
+// SCSP state for a given amount of time. PollInterval is the interval at which
+// the reminders that are due are looked for.
+type RemindersConfig struct {
+	PollInterval time.Duration    `yaml:"poll_interval"`
+	Rules        []ReminderConfig `yaml:"rules"`
+}
+
+// ReminderConfig represents a single reminder, sent once a proposal of the
+// given type has stayed in the given SCSP state for the given delay.
+type ReminderConfig struct {
+	Type  string        `yaml:"type"`
+	State string        `yaml:"state"`
+	Delay time.Duration `yaml:"delay"`
+}
+
+// Reminder returns the reminder configured for the given proposal type and
+// SCSP state.
+// Returns false if no reminder is configured for them.
+func (c *RemindersConfig) Reminder(t string, state string) (*ReminderConfig, bool) {
+	for i := range c.Rules {
+		if c.Rules[i].Type == t && c.Rules[i].State == state {
+			return &(c.Rules[i]), true
+		}
+	}
+
+	return nil, false
+}
+
+// TransitionsConfig represents the transitions part of the configuration file,
+// which defines the SCSP states allowed for each proposal type and the
+// transitions allowed between them. Transitions that aren't allowed aren't
+// announced, and are instead reported to the maintainers' room if one is
+// configured, using the given pattern. Transitions for types that don't have a
+// state machine defined are always allowed.
+type TransitionsConfig struct {
+	MaintainersRoom string                        `yaml:"maintainers_room"`
+	Pattern         string                        `yaml:"pattern"`
+	Types           map[string]StateMachineConfig `yaml:"types"`
+}
+
+// StateMachineConfig represents the SCSP states allowed for a proposal type,
+// and the transitions allowed between them. Transitions maps each state to the
+// states a proposal can go to from it, and Initial lists the states a proposal
+// can start in. A proposal can start in any allowed state if Initial is empty.
+type StateMachineConfig struct {
+	Initial     []string            `yaml:"initial"`
+	Transitions map[string][]string `yaml:"transitions"`
+}
+
+// Allowed checks whether a proposal of the given type is allowed to go from
+// the given SCSP state to the other given one. An empty origin state means the
+// proposal didn't have a SCSP state yet. A proposal staying in the same state
+// is always allowed.
+func (c *TransitionsConfig) Allowed(t string, from string, to string) bool {
+	machine, ok := c.Types[t]
+	if !ok || from == to {
+		return true
+	}
+
+	if !machine.hasState(to) {
+		return false
+	}
+
+	if len(from) == 0 {
+		return len(machine.Initial) == 0 || types.Contains(machine.Initial, to)
+	}
+
+	return types.Contains(machine.Transitions[from], to)
+}
+
+// hasState checks whether the given SCSP state is one of the states allowed by
+// the state machine, i.e. whether it's an initial state, or the origin or the
+// destination of a transition.
+func (m *StateMachineConfig) hasState(state string) bool {
+	if types.Contains(m.Initial, state) {
+		return true
+	}
+
+	for from, to := range m.Transitions {
+		if from == state || types.Contains(to, state) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConflictsConfig represents the conflicts part of the configuration file,
+// which defines how conflicting labels on a proposal (i.e. more than one label
+// defining its type or SCSP state) are handled. Conflicts are reported to the
+// admin room if one is configured, using the given pattern, and resolved
+// according to the given policy.
+type ConflictsConfig struct {
+	AdminRoom string `yaml:"admin_room"`
+	Pattern   string `yaml:"pattern"`
+	Policy    string `yaml:"policy"`
+}
+
 // DatabaseConfig represents the database part of the configuration file.
+// LegacyRepository is the full name of the repository the data saved before
+// the bot supported multiple repositories belongs to.
 type DatabaseConfig struct {
-	Driver     string `yaml:"driver"`
-	DataSource string `yaml:"data_source"`
+	Driver           string `yaml:"driver"`
+	DataSource       string `yaml:"data_source"`
+	LegacyRepository string `yaml:"legacy_repository"`
+}
+
+// Repository returns the configuration for the repository with the given full
+// name, or the configuration for the repository with an empty name if there's
+// no configuration for this specific repository. The comparison is
+// case-insensitive, as GitHub repositories' names are.
+// Returns false if no configuration matches the repository.
+func (c *Config) Repository(name string) (*RepositoryConfig, bool) {
+	var fallback *RepositoryConfig
+	for i := range c.Repositories {
+		repo := &(c.Repositories[i])
+
+		if strings.EqualFold(repo.Name, name) {
+			return repo, true
+		}
+
+		if len(repo.Name) == 0 && fallback == nil {
+			fallback = repo
+		}
+	}
+
+	return fallback, fallback != nil
 }
 
 // Load reads the configuration file located at the provided path, and fills the
 // properties of an instance of the Config structure with its content. It also
-// loads the strings from the notices strings JSON file by parsing it.
+// loads the strings from the notices strings JSON files by parsing them.
 func Load(filePath string) (cfg *Config, err error) {
 	cfg = new(Config)
 
@@ -72,15 +624,161 @@ func Load(filePath string) (cfg *Config, err error) {
 		return
 	}
 
-	strings, err := ioutil.ReadFile(cfg.Notices.StringsFilePath)
-	if err != nil {
+	// Load the strings files, making sure each file is only loaded once even
+	// if several repositories or rooms use it.
+	// The notices' strings file is optional if every repository defines its
+	// own.
+	stringsMaps := make(map[string]map[string]map[string]string)
+	if len(cfg.Notices.StringsFilePath) > 0 {
+		if cfg.Notices.Strings, err = loadStrings(
+			cfg.Notices.StringsFilePath, stringsMaps,
+		); err != nil {
+			return
+		}
+	}
+
+	if err = cfg.Notices.Labels.compile(); err != nil {
 		return
 	}
 
-	if err = json.Unmarshal(strings, &(cfg.Notices.Strings)); err != nil {
+	if cfg.Notices.Rules == nil {
+		cfg.Notices.Rules = defaultNoticeRules()
+	}
+	if err = checkNoticeRules(cfg.Notices.Rules); err != nil {
 		return
 	}
 
+	// If no repository is configured, use the notices part of the
+	// configuration file for every repository.
+	if len(cfg.Repositories) == 0 {
+		cfg.Repositories = []RepositoryConfig{{}}
+	}
+
+	// Fill the repositories' settings that haven't been defined with the ones
+	// from the webhook and notices parts of the configuration file.
+	for i := range cfg.Repositories {
+		repo := &(cfg.Repositories[i])
+
+		if len(repo.Secret) == 0 {
+			repo.Secret = cfg.Webhook.Secret
+		}
+
+		if len(repo.Pattern) == 0 {
+			repo.Pattern = cfg.Notices.Pattern
+		}
+
+		if len(repo.HTMLPattern) == 0 {
+			repo.HTMLPattern = cfg.Notices.HTMLPattern
+		}
+
+		if repo.Rooms == nil {
+			repo.Rooms = cfg.Notices.Rooms
+		}
+
+		if repo.Labels == nil {
+			repo.Labels = &cfg.Notices.Labels
+		} else if err = repo.Labels.compile(); err != nil {
+			return
+		}
+
+		if repo.Rules == nil {
+			repo.Rules = cfg.Notices.Rules
+		} else if err = checkNoticeRules(repo.Rules); err != nil {
+			return
+		}
+
+		if len(repo.StringsFilePath) == 0 {
+			repo.Strings = cfg.Notices.Strings
+		} else if repo.Strings, err = loadStrings(
+			repo.StringsFilePath, stringsMaps,
+		); err != nil {
+			return
+		}
+
+		if repo.Rooms, err = fillRooms(repo, stringsMaps); err != nil {
+			return
+		}
+
+		// The data saved before the bot supported multiple repositories
+		// belongs to the first named repository configured by default.
+		if len(cfg.Database.LegacyRepository) == 0 {
+			cfg.Database.LegacyRepository = repo.Name
+		}
+	}
+
+	// Check if the configured policy for unknown events is supported, and
+	// default to rejecting them.
+	switch cfg.Webhook.UnknownEvents {
+	case "":
+		cfg.Webhook.UnknownEvents = UnknownEventsReject
+	case UnknownEventsReject, UnknownEventsAccept:
+	default:
+		err = ErrUnsupportedUnknownEventsPolicy
+		return
+	}
+
+	if cfg.Matrix.SendAttempts <= 0 {
+		cfg.Matrix.SendAttempts = defaultMatrixSendAttempts
+	}
+
+	if cfg.Matrix.SendBackoff <= 0 {
+		cfg.Matrix.SendBackoff = defaultMatrixSendBackoff
+	}
+
+	if cfg.Webhook.DeliveryRetention <= 0 {
+		cfg.Webhook.DeliveryRetention = defaultDeliveryRetention
+	}
+
+	if cfg.Queue.Workers <= 0 {
+		cfg.Queue.Workers = defaultQueueWorkers
+	}
+
+	if cfg.Queue.MaxAttempts <= 0 {
+		cfg.Queue.MaxAttempts = defaultQueueMaxAttempts
+	}
+
+	if cfg.Queue.Backoff <= 0 {
+		cfg.Queue.Backoff = defaultQueueBackoff
+	}
+
+	if cfg.Queue.PollInterval <= 0 {
+		cfg.Queue.PollInterval = defaultQueuePollInterval
+	}
+
+	if cfg.Reports.StuckLimit <= 0 {
+		cfg.Reports.StuckLimit = defaultReportsStuckLimit
+	}
+
+	if cfg.Reminders.PollInterval <= 0 {
+		cfg.Reminders.PollInterval = defaultReminderInterval
+	}
+
+	for _, r := range cfg.Reminders.Rules {
+		if len(r.Type) == 0 || len(r.State) == 0 || r.Delay <= 0 {
+			err = ErrInvalidReminder
+			return
+		}
+	}
+
+	if len(cfg.Conflicts.Pattern) == 0 {
+		cfg.Conflicts.Pattern = defaultConflictPattern
+	}
+
+	// Check if the configured policy for conflicting labels is supported, and
+	// default to only reporting them.
+	switch cfg.Conflicts.Policy {
+	case "":
+		cfg.Conflicts.Policy = ConflictPolicyReport
+	case ConflictPolicyReport, ConflictPolicyLatest:
+	default:
+		err = ErrUnsupportedConflictPolicy
+		return
+	}
+
+	if len(cfg.Transitions.Pattern) == 0 {
+		cfg.Transitions.Pattern = defaultTransitionPattern
+	}
+
 	// Check if the configured database driver is supported.
 	if _, supported := supportedDBDrivers[cfg.Database.Driver]; !supported {
 		err = ErrUnsupportedDBDriver
@@ -89,3 +787,61 @@ func Load(filePath string) (cfg *Config, err error) {
 
 	return
 }
+
+// fillRooms returns a copy of the given repository's rooms, with the settings
+// that haven't been defined for each room filled with the repository's. A copy
+// is made so that repositories sharing the same rooms don't override each
+// other's settings.
+// Returns an error if the strings file of a room couldn't be loaded.
+func fillRooms(
+	repo *RepositoryConfig, stringsMaps map[string]map[string]map[string]string,
+) (rooms []RoomConfig, err error) {
+	rooms = make([]RoomConfig, len(repo.Rooms))
+	copy(rooms, repo.Rooms)
+
+	for i := range rooms {
+		room := &(rooms[i])
+
+		if len(room.Pattern) == 0 {
+			room.Pattern = repo.Pattern
+		}
+
+		if len(room.HTMLPattern) == 0 {
+			room.HTMLPattern = repo.HTMLPattern
+		}
+
+		if len(room.StringsFilePath) == 0 {
+			room.Strings = repo.Strings
+		} else if room.Strings, err = loadStrings(
+			room.StringsFilePath, stringsMaps,
+		); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// loadStrings reads and parses the strings JSON file located at the provided
+// path, unless it has already been loaded into the provided map of loaded
+// strings files, in which case the previously loaded strings are returned.
+// Returns an error if the file couldn't be read or parsed.
+func loadStrings(
+	filePath string, loaded map[string]map[string]map[string]string,
+) (strs map[string]map[string]string, err error) {
+	if loadedStrs, ok := loaded[filePath]; ok {
+		return loadedStrs, nil
+	}
+
+	rawStrings, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(rawStrings, &strs); err != nil {
+		return
+	}
+
+	loaded[filePath] = strs
+	return
+}
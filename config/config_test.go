@@ -0,0 +1,167 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCheckNoticeRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []NoticeRule
+		invalid bool
+	}{
+		{
+			name:  "default rules",
+			rules: defaultNoticeRules(),
+		},
+		{
+			name:    "no message",
+			rules:   []NoticeRule{{Name: "empty"}},
+			invalid: true,
+		},
+		{
+			name:    "message without section",
+			rules:   []NoticeRule{{Messages: []string{"{{ .State }}"}}},
+			invalid: true,
+		},
+		{
+			name:    "message with invalid template",
+			rules:   []NoticeRule{{Messages: []string{"global/{{ .State"}}},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNoticeRules(tt.rules)
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, rule := range tt.rules {
+				if len(rule.MessageTemplates()) != len(rule.Messages) {
+					t.Errorf(
+						"rule %q has %d parsed messages, expected %d",
+						rule.Name, len(rule.MessageTemplates()), len(rule.Messages),
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestTransitionsAllowed(t *testing.T) {
+	cfg := TransitionsConfig{
+		Types: map[string]StateMachineConfig{
+			"feature": {
+				Initial: []string{"draft"},
+				Transitions: map[string][]string{
+					"draft":   {"pending"},
+					"pending": {"fcp", "draft"},
+					"fcp":     {"merged"},
+				},
+			},
+			"fix": {
+				Transitions: map[string][]string{
+					"pending": {"merged"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		typ     string
+		from    string
+		to      string
+		allowed bool
+	}{
+		{"allowed transition", "feature", "draft", "pending", true},
+		{"transition back", "feature", "pending", "draft", true},
+		{"skipped state", "feature", "draft", "fcp", false},
+		{"unknown destination", "feature", "fcp", "rejected", false},
+		{"same state", "feature", "fcp", "fcp", true},
+		{"same unknown state", "feature", "rejected", "rejected", true},
+		{"initial state", "feature", "", "draft", true},
+		{"non-initial state", "feature", "", "pending", false},
+		{"any known state without initial states", "fix", "", "merged", true},
+		{"unknown state without initial states", "fix", "", "draft", false},
+		{"type without state machine", "docs", "draft", "merged", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := cfg.Allowed(tt.typ, tt.from, tt.to); allowed != tt.allowed {
+				t.Errorf(
+					"transition of a %q from %q to %q: got %v, expected %v",
+					tt.typ, tt.from, tt.to, allowed, tt.allowed,
+				)
+			}
+		})
+	}
+}
+
+func TestLabelGrammarMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		grammar LabelGrammar
+		label   string
+		kind    string
+		value   string
+	}{
+		{"default type", LabelGrammar{}, "type:feature", LabelKindType, "feature"},
+		{"default state", LabelGrammar{}, "scsp:review", LabelKindState, "review"},
+		{"value containing the separator", LabelGrammar{}, "scsp:review:final", LabelKindState, "review:final"},
+		{"unknown prefix", LabelGrammar{}, "area:matrix", "", ""},
+		{"no separator", LabelGrammar{}, "wontfix", "", ""},
+		{"prefix only", LabelGrammar{}, "scsp", "", ""},
+		{
+			"custom prefixes and separator",
+			LabelGrammar{Separator: "/", TypePrefix: "kind", StatePrefix: "status"},
+			"status/fcp", LabelKindState, "fcp",
+		},
+		{
+			"default prefix with custom separator",
+			LabelGrammar{Separator: "/"},
+			"scsp:fcp", "", "",
+		},
+		{
+			"regex with capturing group",
+			LabelGrammar{StateRegex: `^\[(\w+)\]$`},
+			"[pending]", LabelKindState, "pending",
+		},
+		{
+			"regex without capturing group",
+			LabelGrammar{TypeRegex: `^(?:feature|fix)$`},
+			"fix", LabelKindType, "fix",
+		},
+		{
+			"regex replacing the prefix",
+			LabelGrammar{StateRegex: `^\[(\w+)\]$`},
+			"scsp:pending", "", "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.grammar
+			if err := g.compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if kind, value := g.Match(tt.label); kind != tt.kind || value != tt.value {
+				t.Errorf(
+					"label %q: got (%q, %q), expected (%q, %q)",
+					tt.label, kind, value, tt.kind, tt.value,
+				)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/types"
 
 	// Database drivers
 	_ "github.com/lib/pq"
@@ -14,45 +18,761 @@ import (
 
 // Database represents the crawler's database.
 type Database struct {
-	db            *sql.DB
-	proposalState proposalStateStatements
+	db              *sql.DB
+	proposalLabels  proposalLabelsStatements
+	proposalEvents  proposalEventsStatements
+	lastNotice      lastNoticeStatements
+	notices         noticesStatements
+	threadRoots     threadRootsStatements
+	reminders       remindersStatements
+	labelConflicts  labelConflictsStatements
+	deliveries      deliveriesStatements
+	jobs            jobsStatements
+	relayedComments relayedCommentsStatements
 }
 
 // NewDatabase creates a new instance of the Database structure by opening a
 // PostgreSQL database accessible using a given connexion configuration string,
-// and preparing the different statements used.
-// Returns an error if there was an issue opening the database or preparing the
-// different statements.
+// applying the migrations of its schema that haven't been applied yet, and
+// preparing the different statements used. The data saved before the bot
+// supported multiple repositories is assigned to the configured legacy
+// repository.
+// Returns ErrNoLegacyRepository if there's such data but no legacy repository
+// is configured.
+// Returns an error if there was an issue opening or migrating the database, or
+// preparing the different statements.
 func NewDatabase(cfg *config.Config) (database *Database, err error) {
 	database = new(Database)
 
 	if database.db, err = sql.Open(cfg.Database.Driver, cfg.Database.DataSource); err != nil {
 		return
 	}
-	if err = database.proposalState.prepare(database.db); err != nil {
+
+	migrator, err := newMigrator(database.db, cfg.Database.Driver)
+	if err != nil {
+		return
+	}
+	if _, err = migrator.Migrate(false); err != nil {
+		return
+	}
+	if err = assignLegacyRows(
+		database.db, cfg.Database.LegacyRepository,
+	); err != nil {
+		return
+	}
+
+	if err = database.proposalLabels.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.proposalEvents.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.lastNotice.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.notices.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.threadRoots.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.reminders.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.labelConflicts.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.deliveries.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.jobs.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.relayedComments.prepare(database.db); err != nil {
 		return
 	}
 
 	return
 }
 
-// UpdateProposalState updates the state of a proposal, or inserts it if there's
-// no saved state for this proposal.
+// UpdateProposalState updates the state of a proposal after an event happened
+// to it, i.e. replaces its saved labels with the given ones, and appends the
+// event to the proposal's history, both in a single transaction. The event is
+// recorded as having happened now if the time at which it happened isn't
+// known. The event isn't recorded again if an event from the same webhook
+// delivery is already in the proposal's history.
 // Returns an error if we couldn't talk to the database.
-func (d *Database) UpdateProposalState(number int64, labels []string) error {
+func (d *Database) UpdateProposalState(
+	event types.ProposalEvent, labels []string,
+) (err error) {
 	logrus.WithFields(logrus.Fields{
-		"number": number,
-		"labels": labels,
+		"repository":  event.Repository,
+		"number":      event.Number,
+		"labels":      labels,
+		"event":       event.Event,
+		"action":      event.Action,
+		"delivery_id": event.DeliveryID,
+		"notice_sent": event.NoticeSent,
 	}).Debug("Updating proposal state")
-	return d.proposalState.upsertState(number, labels)
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = d.proposalLabels.replaceLabels(
+		tx, event.Repository, event.Number, labels,
+	); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if err = d.proposalEvents.insertEvent(tx, event); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
 }
 
-// GetProposalState retrieves the state of a proposal. Returns an empty slice if
-// no state has been saved for this proposal.
+// GetProposalState retrieves the state of a proposal, i.e. its saved labels.
+// Returns an empty slice if no state has been saved for this proposal.
 // Returns an error if we couldn't talk to the database.
-func (d *Database) GetProposalState(number int64) ([]string, error) {
+func (d *Database) GetProposalState(
+	repository string, number int64,
+) ([]string, error) {
 	logrus.WithFields(logrus.Fields{
-		"number": number,
+		"repository": repository,
+		"number":     number,
 	}).Debug("Retrieving proposal state")
-	return d.proposalState.selectState(number)
+	return d.proposalLabels.selectLabels(repository, number)
+}
+
+// GetProposalsWithLabel retrieves the numbers of the proposals of a repository
+// which saved state includes a given label, in ascending order.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetProposalsWithLabel(
+	repository string, label string,
+) ([]int64, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"label":      label,
+	}).Debug("Retrieving proposals with label")
+	return d.proposalLabels.selectNumbersWithLabel(repository, label)
+}
+
+// RecordProposalEvent appends an event to the history of the proposal it
+// happened to. The event is recorded as having happened now if the time at
+// which it happened isn't known. Does nothing if an event from the same webhook
+// delivery has already been recorded for this proposal.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) RecordProposalEvent(event types.ProposalEvent) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"repository":  event.Repository,
+		"number":      event.Number,
+		"event":       event.Event,
+		"action":      event.Action,
+		"notice_sent": event.NoticeSent,
+	}).Debug("Recording proposal event")
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = d.proposalEvents.insertEvent(tx, event); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+// GetProposalEvents retrieves the history of a proposal, oldest event first.
+// Returns an empty slice if no event has been recorded for this proposal.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetProposalEvents(
+	repository string, number int64,
+) ([]types.ProposalEvent, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Retrieving proposal events")
+	return d.proposalEvents.selectEvents(repository, number)
+}
+
+// GetDeliveryEvent retrieves the event of a proposal which comes from a given
+// webhook delivery, identified by its GUID. Returns false if no such event has
+// been recorded, e.g. because processing the delivery failed before reaching
+// this point.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetDeliveryEvent(
+	repository string, number int64, deliveryID string,
+) (types.ProposalEvent, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository":  repository,
+		"number":      number,
+		"delivery_id": deliveryID,
+	}).Debug("Retrieving delivery event")
+	return d.proposalEvents.selectDeliveryEvent(repository, number, deliveryID)
+}
+
+// GetLatestProposalState retrieves the latest non-empty SCSP state recorded in
+// the history of a proposal, i.e. the SCSP state it was in before its SCSP label
+// was last removed or replaced. Returns an empty string and false if no event
+// recorded for this proposal has a SCSP state.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetLatestProposalState(
+	repository string, number int64,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Retrieving latest proposal state")
+	return d.proposalEvents.selectLatestState(repository, number)
+}
+
+// GetRepositoryEvents retrieves the history of every proposal of a repository,
+// which name is matched case-insensitively, ordered by proposal then oldest
+// event first.
+// Returns an empty slice if no event has been recorded for this repository.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetRepositoryEvents(
+	repository string,
+) ([]types.ProposalEvent, error) {
+	logrus.WithField("repository", repository).Debug("Retrieving repository events")
+	return d.proposalEvents.selectRepositoryEvents(repository)
+}
+
+// UpdateLastNotice saves the message of the latest notice sent for a proposal,
+// replacing the one previously saved if there's one.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) UpdateLastNotice(
+	repository string, number int64, message string,
+) error {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"message":    message,
+	}).Debug("Updating last notice")
+	return d.lastNotice.upsertLastNotice(repository, number, message)
+}
+
+// GetLastNotice retrieves the message of the latest notice sent for a proposal.
+// Returns an empty string and false if no notice has been sent yet for this
+// proposal.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetLastNotice(
+	repository string, number int64,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Retrieving last notice")
+	return d.lastNotice.selectLastNotice(repository, number)
+}
+
+// SaveNotice records a notice that has been sent to a Matrix room, identified
+// by the ID of the Matrix event, along with its body and the type and state of
+// the proposal at the time it was sent. If the notice is an edit of a previous
+// one, replaces must be the ID of the edited event, otherwise it must be empty.
+// editable tells whether the notice announces an update to the proposal's
+// state, and can therefore be edited by the next one.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) SaveNotice(
+	repository string, number int64, roomID string, eventID string,
+	body string, scsType string, state string, replaces string, editable bool,
+) error {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+		"event_id":   eventID,
+		"replaces":   replaces,
+		"editable":   editable,
+	}).Debug("Saving notice")
+	return d.notices.insertNotice(
+		repository, number, roomID, eventID, body, scsType, state, replaces,
+		editable,
+	)
+}
+
+// GetLatestOriginalNotice retrieves the ID of the latest notice sent to a given
+// room for a given proposal, ignoring edits. Returns an empty string and false
+// if no notice has been sent to this room for this proposal yet.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetLatestOriginalNotice(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+	}).Debug("Retrieving latest original notice")
+	return d.notices.selectLatestOriginalNotice(repository, number, roomID)
+}
+
+// GetLatestEditableNotice retrieves the ID of the latest notice announcing an
+// update to the state of a given proposal sent to a given room, ignoring edits.
+// Returns an empty string and false if no such notice has been sent to this
+// room for this proposal yet.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetLatestEditableNotice(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+	}).Debug("Retrieving latest editable notice")
+	return d.notices.selectLatestEditableNotice(repository, number, roomID)
+}
+
+// GetLatestNoticeBody retrieves the body of the latest notice sent to a given
+// room for a given proposal, including edits. Returns an empty string and false
+// if no notice has been sent to this room for this proposal yet.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetLatestNoticeBody(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+	}).Debug("Retrieving latest notice body")
+	return d.notices.selectLatestNoticeBody(repository, number, roomID)
+}
+
+// SaveThreadRoot saves the ID of the event at the root of the Matrix thread of a
+// proposal in a given room. Does nothing if a root has already been saved for
+// this proposal and room.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) SaveThreadRoot(
+	repository string, number int64, roomID string, eventID string,
+) error {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+		"event_id":   eventID,
+	}).Debug("Saving thread root")
+	return d.threadRoots.insertThreadRoot(repository, number, roomID, eventID)
+}
+
+// GetThreadRoot retrieves the ID of the event at the root of the Matrix thread
+// of a proposal in a given room. Returns an empty string and false if there's
+// no thread for this proposal in this room yet.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetThreadRoot(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"room_id":    roomID,
+	}).Debug("Retrieving thread root")
+	return d.threadRoots.selectThreadRoot(repository, number, roomID)
+}
+
+// RecordLabelConflict records a conflict between the labels of a proposal. The
+// conflict is recorded as having happened now if the time at which it happened
+// isn't known.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) RecordLabelConflict(conflict types.LabelConflict) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": conflict.Repository,
+		"number":     conflict.Number,
+		"kind":       conflict.Kind,
+		"labels":     conflict.Labels,
+		"resolution": conflict.Resolution,
+	}).Debug("Recording label conflict")
+
+	if conflict.OccurredAt.IsZero() {
+		conflict.OccurredAt = time.Now()
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = d.labelConflicts.insertConflict(tx, conflict); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+// HasLabelConflict checks whether a conflict between the same labels of the same
+// proposal, defining the same kind of information, has already been recorded,
+// regardless of how it was resolved.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) HasLabelConflict(conflict types.LabelConflict) (bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": conflict.Repository,
+		"number":     conflict.Number,
+		"kind":       conflict.Kind,
+		"labels":     conflict.Labels,
+	}).Debug("Looking up label conflict")
+	return d.labelConflicts.hasConflict(conflict)
+}
+
+// ScheduleReminder saves a reminder for a proposal, replacing the one
+// previously saved for this proposal if it was for another type or SCSP state.
+// Does nothing if a reminder has already been saved for this proposal with the
+// same type and SCSP state, so its due date isn't pushed back.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) ScheduleReminder(reminder types.Reminder) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": reminder.Repository,
+		"number":     reminder.Number,
+		"type":       reminder.Type,
+		"state":      reminder.State,
+		"due_at":     reminder.DueAt,
+	}).Debug("Scheduling reminder")
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = d.reminders.insertReminder(tx, reminder); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+// CancelReminder deletes the reminder saved for a proposal, if any.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) CancelReminder(repository string, number int64) error {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Cancelling reminder")
+	return d.reminders.deleteReminder(repository, number)
+}
+
+// GetDueReminders retrieves the reminders that haven't been sent yet and which
+// due date has elapsed, earliest first.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetDueReminders() ([]types.Reminder, error) {
+	logrus.Debug("Retrieving due reminders")
+	return d.reminders.selectDueReminders(time.Now())
+}
+
+// GetPendingReminder retrieves the reminder saved for a proposal if it hasn't
+// been sent yet. Returns false if there's no such reminder.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) GetPendingReminder(
+	repository string, number int64,
+) (types.Reminder, bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Retrieving pending reminder")
+	return d.reminders.selectPendingReminder(repository, number)
+}
+
+// MarkReminderSent marks a reminder as sent, so it isn't sent again until the
+// proposal enters the same state again.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) MarkReminderSent(reminder types.Reminder) error {
+	logrus.WithFields(logrus.Fields{
+		"repository": reminder.Repository,
+		"number":     reminder.Number,
+		"type":       reminder.Type,
+		"state":      reminder.State,
+	}).Debug("Marking reminder as sent")
+	return d.reminders.updateReminderSent(reminder)
+}
+
+// SaveDelivery saves the outcome of the processing of a webhook delivery,
+// identified by its GUID, replacing the one previously saved for this delivery
+// if there's one.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) SaveDelivery(deliveryID string, event string, outcome string) error {
+	logrus.WithFields(logrus.Fields{
+		"delivery_id": deliveryID,
+		"event":       event,
+		"outcome":     outcome,
+	}).Debug("Saving delivery")
+	return d.deliveries.upsertDelivery(deliveryID, event, outcome)
+}
+
+// IsDeliveryProcessed checks whether a webhook delivery, identified by its
+// GUID, has already been processed successfully.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) IsDeliveryProcessed(deliveryID string) (bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"delivery_id": deliveryID,
+	}).Debug("Retrieving delivery outcome")
+	outcome, _, err := d.deliveries.selectDeliveryOutcome(deliveryID)
+	return outcome == DeliverySucceeded, err
+}
+
+// PruneDeliveries deletes the webhook deliveries last received before the given
+// time, and returns the number of deleted deliveries.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) PruneDeliveries(before time.Time) (int64, error) {
+	logrus.WithFields(logrus.Fields{
+		"before": before,
+	}).Debug("Pruning deliveries")
+	return d.deliveries.deleteDeliveriesBefore(before)
+}
+
+// SaveRelayedComment records that the comment from a given webhook delivery,
+// identified by its GUID, has been relayed to a given Matrix room as the given
+// Matrix event.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) SaveRelayedComment(
+	deliveryID string, roomID string, eventID string,
+) error {
+	logrus.WithFields(logrus.Fields{
+		"delivery_id": deliveryID,
+		"room_id":     roomID,
+		"event_id":    eventID,
+	}).Debug("Saving relayed comment")
+	return d.relayedComments.insertRelayedComment(deliveryID, roomID, eventID)
+}
+
+// IsCommentRelayed checks whether the comment from a given webhook delivery,
+// identified by its GUID, has already been relayed to a given Matrix room.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) IsCommentRelayed(deliveryID string, roomID string) (bool, error) {
+	logrus.WithFields(logrus.Fields{
+		"delivery_id": deliveryID,
+		"room_id":     roomID,
+	}).Debug("Checking whether comment has been relayed")
+	return d.relayedComments.isRelayedComment(deliveryID, roomID)
+}
+
+// PruneRelayedComments deletes the records of the comments relayed before the
+// given time, and returns the number of deleted records.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) PruneRelayedComments(before time.Time) (int64, error) {
+	logrus.WithFields(logrus.Fields{
+		"before": before,
+	}).Debug("Pruning relayed comments")
+	return d.relayedComments.deleteRelayedCommentsBefore(before)
+}
+
+// EnqueueJob queues a webhook payload about a given proposal of a given
+// repository for processing. The repository is empty if the payload isn't
+// about a proposal. If a job with the same ID has permanently failed, it is
+// reset so it can be processed again. Does nothing if a job with the same ID is
+// already queued or has been processed successfully.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) EnqueueJob(
+	id string, deliveryID string, event string, payload []byte,
+	repository string, number int64,
+) error {
+	logrus.WithFields(logrus.Fields{
+		"job_id":      id,
+		"delivery_id": deliveryID,
+		"event":       event,
+		"repository":  repository,
+		"number":      number,
+	}).Debug("Queuing job")
+	return d.jobs.upsertJob(id, deliveryID, event, payload, repository, number)
+}
+
+// ClaimDueJobs retrieves at most limit pending jobs which next attempt is due,
+// oldest first, and marks them as running. A job about a proposal isn't
+// claimed while an earlier job about the same proposal is still pending or
+// running, so the jobs about a proposal are processed one at a time and in
+// order.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) ClaimDueJobs(limit int) ([]types.Job, error) {
+	jobs, err := d.jobs.selectDueJobs(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]types.Job, 0, len(jobs))
+	for _, job := range jobs {
+		ok, err := d.jobs.updateJobStatus(job.ID, jobPending, jobRunning)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			claimed = append(claimed, job)
+		}
+	}
+
+	return claimed, nil
+}
+
+// CompleteJob marks a job as successfully processed.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) CompleteJob(job types.Job) error {
+	logrus.WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"attempts": job.Attempts,
+	}).Debug("Completing job")
+	return d.jobs.updateJobAttempt(job.ID, jobDone, job.Attempts, time.Now(), "")
+}
+
+// RetryJob records a failed attempt at processing a job and schedules a new
+// attempt at the given time.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) RetryJob(job types.Job, nextAttempt time.Time, jobErr error) error {
+	logrus.WithFields(logrus.Fields{
+		"job_id":       job.ID,
+		"attempts":     job.Attempts,
+		"next_attempt": nextAttempt,
+	}).Debug("Scheduling job retry")
+	return d.jobs.updateJobAttempt(
+		job.ID, jobPending, job.Attempts, nextAttempt, jobErr.Error(),
+	)
+}
+
+// FailJob records a failed attempt at processing a job and marks it as
+// permanently failed.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) FailJob(job types.Job, jobErr error) error {
+	logrus.WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"attempts": job.Attempts,
+	}).Debug("Failing job")
+	return d.jobs.updateJobAttempt(
+		job.ID, jobFailed, job.Attempts, time.Now(), jobErr.Error(),
+	)
+}
+
+// ResumeJobs marks the jobs that were running when the bot stopped as pending
+// again, so they can be processed.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) ResumeJobs() error {
+	logrus.Debug("Resuming interrupted jobs")
+	return d.jobs.resetRunningJobs()
+}
+
+// PruneJobs deletes the finished jobs queued before the given time, and returns
+// the number of deleted jobs.
+// Returns an error if we couldn't talk to the database.
+func (d *Database) PruneJobs(before time.Time) (int64, error) {
+	logrus.WithFields(logrus.Fields{
+		"before": before,
+	}).Debug("Pruning jobs")
+	return d.jobs.deleteJobsBefore(before)
+}
+
+// ErrNoLegacyRepository is returned when the database contains data saved
+// before the bot supported multiple repositories, but no repository to assign it
+// to is configured.
+var ErrNoLegacyRepository = fmt.Errorf("The database contains data saved before the bot supported multiple repositories, but no repository to assign it to is configured, please set \"legacy_repository\" in the database settings")
+
+// legacyTables lists the tables keyed on the proposals' repository that
+// predate the support for multiple repositories, along with the columns of
+// their primary key other than the repository.
+var legacyTables = []struct {
+	name string
+	key  []string
+}{
+	{"proposal_labels", []string{"number", "label"}},
+	{"last_notice", []string{"number"}},
+	{"notices", []string{"number", "room_id", "event_id"}},
+	{"thread_roots", []string{"number", "room_id"}},
+}
+
+// assignLegacyRows assigns the rows of the tables keyed on the proposals'
+// repository that predate the support for multiple repositories (i.e. which
+// repository is empty) to the given repository. Legacy rows which have since
+// been superseded by a row saved for this repository with the same primary key
+// are dropped. All of this happens in a single transaction, so the tables are
+// left untouched if one of the steps fails.
+// Returns ErrNoLegacyRepository if there are legacy rows and the given
+// repository is empty.
+// Returns an error if we couldn't talk to the database.
+func assignLegacyRows(db *sql.DB, repository string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+
+	for _, table := range legacyTables {
+		var n int64
+		if err = tx.QueryRow(fmt.Sprintf(
+			"SELECT COUNT(*) FROM %s WHERE repository = ''", table.name,
+		)).Scan(&n); err != nil {
+			tx.Rollback()
+			return
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		// Don't let the existing data be silently ignored, as the proposals
+		// it's about would then be announced again.
+		if len(repository) == 0 {
+			tx.Rollback()
+			return ErrNoLegacyRepository
+		}
+
+		keyMatches := make([]string, 0, len(table.key))
+		for _, column := range table.key {
+			keyMatches = append(keyMatches, fmt.Sprintf(
+				"n.%s = %s.%s", column, table.name, column,
+			))
+		}
+
+		var res sql.Result
+		if res, err = tx.Exec(fmt.Sprintf(
+			`DELETE FROM %s WHERE repository = '' AND EXISTS (
+				SELECT 1 FROM %s AS n WHERE n.repository = $1 AND %s
+			)`, table.name, table.name, strings.Join(keyMatches, " AND "),
+		), repository); err != nil {
+			tx.Rollback()
+			return
+		}
+
+		if dropped, err := res.RowsAffected(); err == nil && dropped > 0 {
+			logrus.WithFields(logrus.Fields{
+				"table":      table.name,
+				"repository": repository,
+				"rows":       dropped,
+			}).Warn("Dropped legacy rows superseded by the repository's rows")
+		}
+
+		if res, err = tx.Exec(fmt.Sprintf(
+			"UPDATE %s SET repository = $1 WHERE repository = ''", table.name,
+		), repository); err != nil {
+			tx.Rollback()
+			return
+		}
+
+		if assigned, err := res.RowsAffected(); err == nil && assigned > 0 {
+			logrus.WithFields(logrus.Fields{
+				"table":      table.name,
+				"repository": repository,
+				"rows":       assigned,
+			}).Info("Assigned legacy rows to repository")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// toMillis converts a time into a timestamp in milliseconds, which is how times
+// are stored in the database.
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis converts a timestamp in milliseconds, which is how times are
+// stored in the database, into a time.
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
 }
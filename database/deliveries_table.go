@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Outcomes of the processing of a webhook delivery.
+const (
+	// DeliverySucceeded means the delivery has been processed successfully.
+	DeliverySucceeded = "success"
+	// DeliveryFailed means there was an error processing the delivery.
+	DeliveryFailed = "failure"
+)
+
+// The schema of the deliveries table is defined by the migrations in migrations.go.
+
+const upsertDeliverySQL = `
+	INSERT INTO deliveries (delivery_id, event, outcome, received_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (delivery_id) DO UPDATE SET outcome = $3, received_at = $4
+`
+
+const selectDeliveryOutcomeSQL = `
+	SELECT outcome FROM deliveries WHERE delivery_id = $1
+`
+
+const deleteDeliveriesBeforeSQL = `
+	DELETE FROM deliveries WHERE received_at < $1
+`
+
+type deliveriesStatements struct {
+	upsertDeliveryStmt         *sql.Stmt
+	selectDeliveryOutcomeStmt  *sql.Stmt
+	deleteDeliveriesBeforeStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (ds *deliveriesStatements) prepare(db *sql.DB) (err error) {
+	if ds.upsertDeliveryStmt, err = db.Prepare(upsertDeliverySQL); err != nil {
+		return
+	}
+	if ds.selectDeliveryOutcomeStmt, err = db.Prepare(selectDeliveryOutcomeSQL); err != nil {
+		return
+	}
+	if ds.deleteDeliveriesBeforeStmt, err = db.Prepare(deleteDeliveriesBeforeSQL); err != nil {
+		return
+	}
+	return
+}
+
+// upsertDelivery saves the outcome of the processing of a delivery, along with
+// the current time, or updates it if the delivery has already been received.
+// Returns an error if we couldn't talk to the database.
+func (ds *deliveriesStatements) upsertDelivery(
+	deliveryID string, event string, outcome string,
+) error {
+	_, err := ds.upsertDeliveryStmt.Exec(
+		deliveryID, event, outcome, toMillis(time.Now()),
+	)
+	return err
+}
+
+// selectDeliveryOutcome retrieves the outcome of the latest processing of a
+// delivery. Returns an empty string and false if the delivery hasn't been
+// received yet.
+// Returns an error if we couldn't talk to the database.
+func (ds *deliveriesStatements) selectDeliveryOutcome(
+	deliveryID string,
+) (string, bool, error) {
+	var outcome string
+
+	err := ds.selectDeliveryOutcomeStmt.QueryRow(deliveryID).Scan(&outcome)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return outcome, true, nil
+}
+
+// deleteDeliveriesBefore deletes the deliveries last received before the given
+// time, and returns the number of deleted deliveries.
+// Returns an error if we couldn't talk to the database.
+func (ds *deliveriesStatements) deleteDeliveriesBefore(before time.Time) (int64, error) {
+	res, err := ds.deleteDeliveriesBeforeStmt.Exec(toMillis(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+// Statuses of a job in the queue.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// The schema of the jobs table is defined by the migrations in migrations.go.
+
+// Queuing a job that has permanently failed resets it, so that an
+// administrator can send a delivery again to have it processed.
+const upsertJobSQL = `
+	INSERT INTO jobs (id, delivery_id, event, payload, status, attempts, next_attempt_at, last_error, created_at, repository, number)
+	VALUES ($1, $2, $3, $4, 'pending', 0, $5, '', $5, $6, $7)
+	ON CONFLICT (id) DO UPDATE SET payload = $4, status = 'pending', attempts = 0, next_attempt_at = $5, last_error = ''
+	WHERE jobs.status = 'failed'
+`
+
+// Jobs about a proposal are processed in the order they were queued in, so a
+// job isn't due while an earlier job about the same proposal is still pending
+// (e.g. waiting to be retried) or running.
+const selectDueJobsSQL = `
+	SELECT j.id, j.delivery_id, j.event, j.payload, j.attempts FROM jobs AS j
+	WHERE j.status = 'pending' AND j.next_attempt_at <= $1
+	AND (j.repository = '' OR NOT EXISTS (
+		SELECT 1 FROM jobs AS e
+		WHERE e.repository = j.repository AND e.number = j.number
+		AND e.status IN ('pending', 'running')
+		AND (e.created_at < j.created_at OR (e.created_at = j.created_at AND e.id < j.id))
+	))
+	ORDER BY j.created_at ASC, j.id ASC LIMIT $2
+`
+
+// Parameters are numbered in order of appearance in the statements, as SQLite
+// treats them as named parameters rather than positional ones.
+const updateJobStatusSQL = `
+	UPDATE jobs SET status = $1 WHERE id = $2 AND status = $3
+`
+
+const updateJobAttemptSQL = `
+	UPDATE jobs SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4
+	WHERE id = $5
+`
+
+const resetRunningJobsSQL = `
+	UPDATE jobs SET status = 'pending' WHERE status = 'running'
+`
+
+const deleteJobsBeforeSQL = `
+	DELETE FROM jobs WHERE status IN ('done', 'failed') AND created_at < $1
+`
+
+type jobsStatements struct {
+	upsertJobStmt        *sql.Stmt
+	selectDueJobsStmt    *sql.Stmt
+	updateJobStatusStmt  *sql.Stmt
+	updateJobAttemptStmt *sql.Stmt
+	resetRunningJobsStmt *sql.Stmt
+	deleteJobsBeforeStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (js *jobsStatements) prepare(db *sql.DB) (err error) {
+	if js.upsertJobStmt, err = db.Prepare(upsertJobSQL); err != nil {
+		return
+	}
+	if js.selectDueJobsStmt, err = db.Prepare(selectDueJobsSQL); err != nil {
+		return
+	}
+	if js.updateJobStatusStmt, err = db.Prepare(updateJobStatusSQL); err != nil {
+		return
+	}
+	if js.updateJobAttemptStmt, err = db.Prepare(updateJobAttemptSQL); err != nil {
+		return
+	}
+	if js.resetRunningJobsStmt, err = db.Prepare(resetRunningJobsSQL); err != nil {
+		return
+	}
+	if js.deleteJobsBeforeStmt, err = db.Prepare(deleteJobsBeforeSQL); err != nil {
+		return
+	}
+	return
+}
+
+// upsertJob queues a job about the given proposal, or resets it if a job with
+// the same ID has permanently failed. Does nothing if a job with the same ID is
+// already queued or has been processed successfully.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) upsertJob(
+	id string, deliveryID string, event string, payload []byte,
+	repository string, number int64,
+) error {
+	_, err := js.upsertJobStmt.Exec(
+		id, deliveryID, event, string(payload), toMillis(time.Now()),
+		repository, number,
+	)
+	return err
+}
+
+// selectDueJobs retrieves at most limit pending jobs which next attempt is due
+// and which don't have to wait for an earlier job about the same proposal,
+// oldest first.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) selectDueJobs(limit int) ([]types.Job, error) {
+	rows, err := js.selectDueJobsStmt.Query(toMillis(time.Now()), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]types.Job, 0)
+	for rows.Next() {
+		var job types.Job
+		var payload string
+		if err = rows.Scan(
+			&job.ID, &job.DeliveryID, &job.Event, &payload, &job.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		job.Payload = []byte(payload)
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// updateJobStatus changes the status of a job from one status to another, and
+// returns false if the job wasn't in the expected status.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) updateJobStatus(id string, from string, to string) (bool, error) {
+	res, err := js.updateJobStatusStmt.Exec(to, id, from)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// updateJobAttempt records the result of an attempt at processing a job.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) updateJobAttempt(
+	id string, status string, attempts int, nextAttempt time.Time,
+	lastError string,
+) error {
+	_, err := js.updateJobAttemptStmt.Exec(
+		status, attempts, toMillis(nextAttempt), lastError, id,
+	)
+	return err
+}
+
+// resetRunningJobs marks the running jobs as pending again. It is meant to be
+// called on startup, in order to resume the jobs that were interrupted.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) resetRunningJobs() error {
+	_, err := js.resetRunningJobsStmt.Exec()
+	return err
+}
+
+// deleteJobsBefore deletes the finished jobs queued before the given time, and
+// returns the number of deleted jobs.
+// Returns an error if we couldn't talk to the database.
+func (js *jobsStatements) deleteJobsBefore(before time.Time) (int64, error) {
+	res, err := js.deleteJobsBeforeStmt.Exec(toMillis(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+// The schema of the label_conflicts table is defined by the migrations in migrations.go.
+
+const selectNextConflictSeqSQL = `
+	SELECT COALESCE(MAX(seq) + 1, 0) FROM label_conflicts
+	WHERE repository = $1 AND number = $2
+`
+
+const insertConflictSQL = `
+	INSERT INTO label_conflicts (
+		repository, number, seq, occurred_at, actor, kind, labels, resolution
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+const selectConflictSQL = `
+	SELECT 1 FROM label_conflicts
+	WHERE repository = $1 AND number = $2 AND kind = $3 AND labels = $4
+`
+
+type labelConflictsStatements struct {
+	selectNextConflictSeqStmt *sql.Stmt
+	insertConflictStmt        *sql.Stmt
+	selectConflictStmt        *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (cs *labelConflictsStatements) prepare(db *sql.DB) (err error) {
+	if cs.selectNextConflictSeqStmt, err = db.Prepare(selectNextConflictSeqSQL); err != nil {
+		return
+	}
+	if cs.insertConflictStmt, err = db.Prepare(insertConflictSQL); err != nil {
+		return
+	}
+	if cs.selectConflictStmt, err = db.Prepare(selectConflictSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertConflict records a conflict between the labels of a proposal, as part
+// of the given transaction. The conflicting labels are saved in alphabetical
+// order, so the same conflict can be looked up regardless of the order the
+// labels were listed in.
+// Returns an error if we couldn't talk to the database.
+func (cs *labelConflictsStatements) insertConflict(
+	tx *sql.Tx, conflict types.LabelConflict,
+) (err error) {
+	var seq int64
+	if err = tx.Stmt(cs.selectNextConflictSeqStmt).QueryRow(
+		conflict.Repository, conflict.Number,
+	).Scan(&seq); err != nil {
+		return
+	}
+
+	labels, err := encodeConflictLabels(conflict.Labels)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Stmt(cs.insertConflictStmt).Exec(
+		conflict.Repository, conflict.Number, seq, toMillis(conflict.OccurredAt),
+		conflict.Actor, conflict.Kind, labels, conflict.Resolution,
+	)
+	return
+}
+
+// hasConflict checks whether a conflict between the same labels of a proposal,
+// defining the same kind of information, has already been recorded.
+// Returns an error if we couldn't talk to the database.
+func (cs *labelConflictsStatements) hasConflict(
+	conflict types.LabelConflict,
+) (bool, error) {
+	labels, err := encodeConflictLabels(conflict.Labels)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := cs.selectConflictStmt.Query(
+		conflict.Repository, conflict.Number, conflict.Kind, labels,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+// encodeConflictLabels encodes the given list of conflicting labels into JSON,
+// in alphabetical order.
+func encodeConflictLabels(labels []string) (string, error) {
+	sorted := append([]string{}, labels...)
+	sort.Strings(sorted)
+	return encodeLabels(sorted)
+}
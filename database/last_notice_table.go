@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// The schema of the last_notice table is defined by the migrations in migrations.go.
+
+const upsertLastNoticeSQL = `
+	INSERT INTO last_notice (repository, number, message) VALUES ($1, $2, $3)
+	ON CONFLICT (repository, number) DO UPDATE SET message = $3
+`
+
+const selectLastNoticeSQL = `
+	SELECT message FROM last_notice WHERE repository = $1 AND number = $2
+`
+
+type lastNoticeStatements struct {
+	upsertLastNoticeStmt *sql.Stmt
+	selectLastNoticeStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (ls *lastNoticeStatements) prepare(db *sql.DB) (err error) {
+	if ls.upsertLastNoticeStmt, err = db.Prepare(upsertLastNoticeSQL); err != nil {
+		return
+	}
+	if ls.selectLastNoticeStmt, err = db.Prepare(selectLastNoticeSQL); err != nil {
+		return
+	}
+	return
+}
+
+// upsertLastNotice updates the message of the latest notice sent for a
+// proposal, or inserts it if no notice has been sent yet for this proposal.
+// Returns an error if we couldn't talk to the database.
+func (ls *lastNoticeStatements) upsertLastNotice(
+	repository string, number int64, message string,
+) error {
+	_, err := ls.upsertLastNoticeStmt.Exec(repository, number, message)
+	return err
+}
+
+// selectLastNotice retrieves the message of the latest notice sent for a
+// proposal. Returns an empty string and false if no notice has been sent yet
+// for this proposal.
+// Returns an error if we couldn't talk to the database.
+func (ls *lastNoticeStatements) selectLastNotice(
+	repository string, number int64,
+) (string, bool, error) {
+	var message string
+
+	if err := ls.selectLastNoticeStmt.QueryRow(repository, number).Scan(&message); err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return message, true, nil
+}
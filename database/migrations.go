@@ -0,0 +1,490 @@
+package database
+
+import (
+	"fmt"
+)
+
+// Names of the database drivers, as used in the configuration file.
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite3"
+)
+
+// migration is a step in the evolution of the database's schema. Migrations are
+// applied in the order of their version, and each of them is only applied once.
+// Once a migration has been released, its statements must never be changed, a
+// new migration must be added instead.
+type migration struct {
+	// Version of the schema the migration upgrades the database to.
+	version int
+	// Short description of the changes made by the migration.
+	description string
+	// Statements to run for each supported database driver, in order.
+	statements map[string][]string
+}
+
+// migrations lists every migration of the database's schema, ordered by
+// version.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Create the initial tables",
+		statements: map[string][]string{
+			driverPostgres: initialSchema,
+			driverSQLite:   initialSchema,
+		},
+	},
+	{
+		version:     2,
+		description: "Key the proposals' data on their repository",
+		statements: map[string][]string{
+			driverPostgres: concat(
+				postgresAddRepository("proposal_state", "number"),
+				postgresAddRepository("last_notice", "number"),
+				postgresAddRepository("notices", "number, room_id, event_id"),
+				postgresAddRepository("thread_roots", "number, room_id"),
+			),
+			driverSQLite: concat(
+				sqliteAddRepository("proposal_state", proposalStateSchemaV2, "number, labels"),
+				sqliteAddRepository("last_notice", lastNoticeSchemaV2, "number, message"),
+				sqliteAddRepository(
+					"notices", noticesSchemaV2,
+					"number, room_id, event_id, body, type, state, sent_at, replaces",
+				),
+				sqliteAddRepository("thread_roots", threadRootsSchemaV2, "number, room_id, event_id"),
+			),
+		},
+	},
+	{
+		version:     3,
+		description: "Store the proposals' labels in their own table",
+		statements: map[string][]string{
+			driverPostgres: {
+				proposalLabelsSchema,
+				proposalLabelsIndex,
+				`INSERT INTO proposal_labels (repository, number, label, ordinal)
+				SELECT DISTINCT ON (s.repository, s.number, l.label) s.repository, s.number, l.label, l.ordinal - 1
+				FROM proposal_state s, unnest(string_to_array(s.labels, ',')) WITH ORDINALITY AS l(label, ordinal)
+				WHERE s.labels != ''
+				ORDER BY s.repository, s.number, l.label, l.ordinal`,
+				"DROP TABLE proposal_state",
+			},
+			driverSQLite: {
+				proposalLabelsSchema,
+				proposalLabelsIndex,
+				// Split the comma-separated lists of labels with a recursive
+				// query, as SQLite doesn't have a function to do it.
+				`INSERT OR IGNORE INTO proposal_labels (repository, number, label, ordinal)
+				WITH RECURSIVE split (repository, number, label, ordinal, rest) AS (
+					SELECT repository, number, '', -1, labels || ',' FROM proposal_state
+					WHERE labels != ''
+					UNION ALL
+					SELECT repository, number, substr(rest, 1, instr(rest, ',') - 1),
+						ordinal + 1, substr(rest, instr(rest, ',') + 1)
+					FROM split WHERE rest != ''
+				)
+				SELECT repository, number, label, ordinal FROM split WHERE ordinal >= 0
+				ORDER BY ordinal`,
+				"DROP TABLE proposal_state",
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "Record the history of the proposals' events",
+		statements: map[string][]string{
+			driverPostgres: {proposalEventsSchema},
+			driverSQLite:   {proposalEventsSchema},
+		},
+	},
+	{
+		version:     5,
+		description: "Store the reminders scheduled for the proposals",
+		statements: map[string][]string{
+			driverPostgres: {remindersSchema},
+			driverSQLite:   {remindersSchema},
+		},
+	},
+	{
+		version:     6,
+		description: "Record the conflicts between the proposals' labels",
+		statements: map[string][]string{
+			driverPostgres: {labelConflictsSchema},
+			driverSQLite:   {labelConflictsSchema},
+		},
+	},
+	{
+		version:     7,
+		description: "Record the kind of information defined by conflicting labels",
+		statements: map[string][]string{
+			driverPostgres: {renameStateConflictKind},
+			driverSQLite:   {renameStateConflictKind},
+		},
+	},
+	{
+		version:     8,
+		description: "Key the proposals' events on the webhook delivery they come from",
+		statements: map[string][]string{
+			driverPostgres: proposalEventsDelivery,
+			driverSQLite:   proposalEventsDelivery,
+		},
+	},
+	{
+		version:     9,
+		description: "Record which notices can be edited by later updates",
+		statements: map[string][]string{
+			driverPostgres: {noticesEditable},
+			driverSQLite:   {noticesEditable},
+		},
+	},
+	{
+		version:     10,
+		description: "Key the jobs on the proposal their payload is about",
+		statements: map[string][]string{
+			driverPostgres: jobsProposal,
+			driverSQLite:   jobsProposal,
+		},
+	},
+	{
+		version:     11,
+		description: "Record the comments relayed to each room",
+		statements: map[string][]string{
+			driverPostgres: {relayedCommentsSchema},
+			driverSQLite:   {relayedCommentsSchema},
+		},
+	},
+}
+
+// The tables are created only if they don't exist, as they used to be created
+// when the bot started, before migrations were introduced.
+var initialSchema = []string{`
+-- Store proposal states
+CREATE TABLE IF NOT EXISTS proposal_state (
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER PRIMARY KEY,
+	-- Comma-separated list of labels, in the latest state of the proposal we know about.
+	labels TEXT NOT NULL
+)`, `
+-- Store the message of the latest notice sent for each proposal
+CREATE TABLE IF NOT EXISTS last_notice (
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER PRIMARY KEY,
+	-- Message (as found in the strings file) of the latest notice sent for this proposal.
+	message TEXT NOT NULL
+)`, `
+-- Store every notice sent to the Matrix rooms
+CREATE TABLE IF NOT EXISTS notices (
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- ID of the Matrix room the notice was sent to
+	room_id TEXT NOT NULL,
+	-- ID of the Matrix event for the notice
+	event_id TEXT NOT NULL,
+	-- Rendered body of the notice
+	body TEXT NOT NULL,
+	-- Type of the proposal at the time the notice was sent, if any
+	type TEXT NOT NULL,
+	-- SCSP state of the proposal at the time the notice was sent, if any
+	state TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the notice was sent
+	sent_at BIGINT NOT NULL,
+	-- ID of the Matrix event this notice is an edit of, or an empty string if
+	-- it isn't an edit
+	replaces TEXT NOT NULL,
+	PRIMARY KEY (number, room_id, event_id)
+)`, `
+-- Store the root of the Matrix thread of each proposal in each room
+CREATE TABLE IF NOT EXISTS thread_roots (
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- ID of the Matrix room the thread lives in
+	room_id TEXT NOT NULL,
+	-- ID of the Matrix event at the root of the thread
+	event_id TEXT NOT NULL,
+	PRIMARY KEY (number, room_id)
+)`, `
+-- Store the webhook deliveries received from GitHub
+CREATE TABLE IF NOT EXISTS deliveries (
+	-- GUID of the delivery, from the X-GitHub-Delivery header
+	delivery_id TEXT PRIMARY KEY,
+	-- Name of the GitHub event, from the X-GitHub-Event header
+	event TEXT NOT NULL,
+	-- Outcome of the latest processing of the delivery
+	outcome TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the delivery was last received
+	received_at BIGINT NOT NULL
+)`, `
+-- Store the webhook payloads waiting to be processed
+CREATE TABLE IF NOT EXISTS jobs (
+	-- Identifier of the job, which is the GUID of the delivery if known
+	id TEXT PRIMARY KEY,
+	-- GUID of the delivery the payload comes from, if known
+	delivery_id TEXT NOT NULL,
+	-- Name of the GitHub event
+	event TEXT NOT NULL,
+	-- JSON-encoded payload
+	payload TEXT NOT NULL,
+	-- Status of the job, either "pending", "running", "done" or "failed"
+	status TEXT NOT NULL,
+	-- Number of times processing the job has been attempted
+	attempts INTEGER NOT NULL,
+	-- Timestamp (in milliseconds) before which the job mustn't be processed
+	next_attempt_at BIGINT NOT NULL,
+	-- Error returned by the latest attempt, if any
+	last_error TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the job was queued
+	created_at BIGINT NOT NULL
+)`,
+}
+
+const proposalStateSchemaV2 = `
+-- Store proposal states
+CREATE TABLE proposal_state (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Comma-separated list of labels, in the latest state of the proposal we know about.
+	labels TEXT NOT NULL,
+	PRIMARY KEY (repository, number)
+)`
+
+const labelConflictsSchema = `
+-- Store the conflicts between the labels of each proposal, i.e. labels
+-- defining more than one type or SCSP state
+CREATE TABLE label_conflicts (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Position of the conflict in the proposal's conflicts, starting at 0
+	seq INTEGER NOT NULL,
+	-- Timestamp (in milliseconds) at which the conflict was detected
+	occurred_at BIGINT NOT NULL,
+	-- Login of the GitHub user who triggered the event revealing the conflict
+	actor TEXT NOT NULL,
+	-- Prefix shared by the conflicting labels (e.g. "type" or "scsp")
+	kind TEXT NOT NULL,
+	-- JSON-encoded list of the conflicting labels
+	labels TEXT NOT NULL,
+	-- Label picked to resolve the conflict, empty if it wasn't resolved
+	resolution TEXT NOT NULL,
+	PRIMARY KEY (repository, number, seq)
+)`
+
+// The conflicts' kind used to be the prefix shared by the conflicting labels,
+// which is "scsp" for the labels defining a SCSP state with the default label
+// grammar.
+const renameStateConflictKind = `
+UPDATE label_conflicts SET kind = 'state' WHERE kind = 'scsp'
+`
+
+const lastNoticeSchemaV2 = `
+-- Store the message of the latest notice sent for each proposal
+CREATE TABLE last_notice (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Message (as found in the strings file) of the latest notice sent for this proposal.
+	message TEXT NOT NULL,
+	PRIMARY KEY (repository, number)
+)`
+
+const noticesSchemaV2 = `
+-- Store every notice sent to the Matrix rooms
+CREATE TABLE notices (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- ID of the Matrix room the notice was sent to
+	room_id TEXT NOT NULL,
+	-- ID of the Matrix event for the notice
+	event_id TEXT NOT NULL,
+	-- Rendered body of the notice
+	body TEXT NOT NULL,
+	-- Type of the proposal at the time the notice was sent, if any
+	type TEXT NOT NULL,
+	-- SCSP state of the proposal at the time the notice was sent, if any
+	state TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the notice was sent
+	sent_at BIGINT NOT NULL,
+	-- ID of the Matrix event this notice is an edit of, or an empty string if
+	-- it isn't an edit
+	replaces TEXT NOT NULL,
+	PRIMARY KEY (repository, number, room_id, event_id)
+)`
+
+const threadRootsSchemaV2 = `
+-- Store the root of the Matrix thread of each proposal in each room
+CREATE TABLE thread_roots (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- ID of the Matrix room the thread lives in
+	room_id TEXT NOT NULL,
+	-- ID of the Matrix event at the root of the thread
+	event_id TEXT NOT NULL,
+	PRIMARY KEY (repository, number, room_id)
+)`
+
+const proposalLabelsSchema = `
+-- Store the labels of each proposal, in the latest state of the proposal we know about
+CREATE TABLE proposal_labels (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Name of the label
+	label TEXT NOT NULL,
+	-- Position of the label in the proposal's list of labels
+	ordinal INTEGER NOT NULL,
+	PRIMARY KEY (repository, number, label)
+)`
+
+const proposalLabelsIndex = `
+CREATE INDEX proposal_labels_label_idx ON proposal_labels (repository, label)
+`
+
+const proposalEventsSchema = `
+-- Store every event processed for each proposal, in an append-only fashion
+CREATE TABLE proposal_events (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Position of the event in the proposal's history, starting at 0
+	seq INTEGER NOT NULL,
+	-- Timestamp (in milliseconds) at which the event happened
+	occurred_at BIGINT NOT NULL,
+	-- Login of the GitHub user who triggered the event
+	actor TEXT NOT NULL,
+	-- Name of the GitHub event
+	event TEXT NOT NULL,
+	-- Action described by the event (e.g. "labeled", "merged" or "approved")
+	action TEXT NOT NULL,
+	-- JSON-encoded list of the labels added to the proposal by the event
+	labels_added TEXT NOT NULL,
+	-- JSON-encoded list of the labels removed from the proposal by the event
+	labels_removed TEXT NOT NULL,
+	-- Type of the proposal after the event, if any
+	type TEXT NOT NULL,
+	-- SCSP state of the proposal after the event, if any
+	state TEXT NOT NULL,
+	-- Whether a notice was sent for the event
+	notice_sent BOOLEAN NOT NULL,
+	PRIMARY KEY (repository, number, seq)
+)`
+
+// Events recorded before this migration, and events which delivery isn't known,
+// have an empty delivery ID, and can't be told apart.
+var proposalEventsDelivery = []string{`
+-- GUID of the webhook delivery the event comes from, if known
+ALTER TABLE proposal_events ADD COLUMN delivery_id TEXT NOT NULL DEFAULT ''
+`, `
+CREATE UNIQUE INDEX proposal_events_delivery_idx
+ON proposal_events (repository, number, delivery_id) WHERE delivery_id <> ''
+`,
+}
+
+// Notices sent before this migration could all be edited.
+const noticesEditable = `
+-- Whether the notice announces an update to the proposal's state, and can
+-- therefore be edited by the next one in rooms configured to do so
+ALTER TABLE notices ADD COLUMN editable BOOLEAN NOT NULL DEFAULT TRUE
+`
+
+// Jobs queued before this migration aren't about any proposal, and are
+// processed regardless of the other jobs.
+var jobsProposal = []string{`
+-- Full name of the repository the payload is about, if any
+ALTER TABLE jobs ADD COLUMN repository TEXT NOT NULL DEFAULT ''
+`, `
+-- Numeric identifier of the proposal the payload is about, if any
+ALTER TABLE jobs ADD COLUMN number INTEGER NOT NULL DEFAULT 0
+`, `
+CREATE INDEX jobs_proposal_idx ON jobs (repository, number, status)
+`,
+}
+
+const relayedCommentsSchema = `
+-- Store the rooms each comment has been relayed to
+CREATE TABLE relayed_comments (
+	-- GUID of the webhook delivery the comment comes from
+	delivery_id TEXT NOT NULL,
+	-- ID of the Matrix room the comment was relayed to
+	room_id TEXT NOT NULL,
+	-- ID of the Matrix event for the relayed comment
+	event_id TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the comment was relayed
+	relayed_at BIGINT NOT NULL,
+	PRIMARY KEY (delivery_id, room_id)
+)`
+
+const remindersSchema = `
+-- Store the reminder scheduled for each proposal, if any
+CREATE TABLE reminders (
+	-- Full name of the repository the proposal belongs to
+	repository TEXT NOT NULL,
+	-- Numeric identifier of the proposal, i.e. the issue/PR's numeric ID
+	number INTEGER NOT NULL,
+	-- Type of the proposal when the reminder was scheduled
+	type TEXT NOT NULL,
+	-- SCSP state of the proposal when the reminder was scheduled
+	state TEXT NOT NULL,
+	-- Title of the proposal
+	title TEXT NOT NULL,
+	-- URL of the proposal
+	url TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the reminder is due
+	due_at BIGINT NOT NULL,
+	-- Whether the reminder has been sent
+	sent BOOLEAN NOT NULL,
+	PRIMARY KEY (repository, number)
+)`
+
+// postgresAddRepository returns the statements adding a repository column to
+// the given table on PostgreSQL, and making it part of the table's primary key
+// along with the given columns. Existing rows get an empty repository, and are
+// assigned to the legacy repository when the database is opened.
+func postgresAddRepository(table string, primaryKey string) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN repository TEXT NOT NULL DEFAULT ''", table),
+		fmt.Sprintf("ALTER TABLE %s ALTER COLUMN repository DROP DEFAULT", table),
+		fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s_pkey", table, table),
+		fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (repository, %s)", table, primaryKey),
+	}
+}
+
+// sqliteAddRepository returns the statements recreating the given table from
+// the given schema on SQLite, which can't alter a table's primary key, and
+// copying its rows, which columns are the given ones, back into it. Existing
+// rows get an empty repository, and are assigned to the legacy repository when
+// the database is opened.
+func sqliteAddRepository(table string, schema string, columns string) []string {
+	return []string{
+		fmt.Sprintf(
+			"CREATE TEMPORARY TABLE %s_legacy AS SELECT %s FROM %s",
+			table, columns, table,
+		),
+		fmt.Sprintf("DROP TABLE %s", table),
+		schema,
+		fmt.Sprintf(
+			"INSERT INTO %s (repository, %s) SELECT '', %s FROM %s_legacy",
+			table, columns, columns, table,
+		),
+		fmt.Sprintf("DROP TABLE %s_legacy", table),
+	}
+}
+
+// concat returns a single slice containing the statements of every given
+// slice, in order.
+func concat(statements ...[]string) []string {
+	all := make([]string, 0)
+	for _, s := range statements {
+		all = append(all, s...)
+	}
+	return all
+}
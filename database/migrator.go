@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Informo/specs-bot/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MigrationStatus describes a migration of the database's schema, i.e. its
+// version, its description and the statements it runs with the configured
+// database driver, and whether it has been applied to the database.
+// AppliedAt is the zero time if the migration hasn't been applied, or if it was
+// applied before the bot recorded the migrations it applies.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Statements  []string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// Migrator applies the migrations of the database's schema.
+type Migrator struct {
+	db       *sql.DB
+	driver   string
+	versions schemaVersionStatements
+}
+
+// NewMigrator creates a new instance of the Migrator structure by opening the
+// configured database, and creating the table recording the migrations applied
+// to it if it doesn't exist.
+// Returns an error if there was an issue opening the database or creating the
+// table.
+func NewMigrator(cfg *config.Config) (*Migrator, error) {
+	db, err := sql.Open(cfg.Database.Driver, cfg.Database.DataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMigrator(db, cfg.Database.Driver)
+}
+
+// newMigrator creates a new instance of the Migrator structure for the given
+// database, which uses the given driver.
+// Returns an error if the table recording the migrations applied to the
+// database couldn't be created.
+func newMigrator(db *sql.DB, driver string) (m *Migrator, err error) {
+	m = &Migrator{
+		db:     db,
+		driver: driver,
+	}
+
+	err = m.versions.prepare(db)
+	return
+}
+
+// Status returns the status of every migration, ordered by version.
+// Returns an error if we couldn't talk to the database.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		appliedAt, ok := applied[mig.version]
+		statuses = append(statuses, MigrationStatus{
+			Version:     mig.version,
+			Description: mig.description,
+			Statements:  mig.statements[m.driver],
+			Applied:     ok,
+			AppliedAt:   appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Migrate applies the migrations that haven't been applied to the database yet,
+// in order, and returns them. Each migration is applied in its own transaction,
+// so a migration that fails leaves the database as it was before it. If dryRun
+// is true, the migrations are returned without being applied.
+// Returns an error if one of the migrations couldn't be applied, in which case
+// the migrations before it remain applied.
+func (m *Migrator) Migrate(dryRun bool) (pending []MigrationStatus, err error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return
+	}
+
+	pending = make([]MigrationStatus, 0)
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s)
+		}
+	}
+
+	if dryRun {
+		return
+	}
+
+	if err = m.recordBaseline(statuses); err != nil {
+		return
+	}
+
+	for i := range pending {
+		if err = m.apply(&pending[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// apply runs the statements of the given migration and records it as applied,
+// in a single transaction.
+// Returns an error if the migration has no statements for the database's
+// driver, or if we couldn't talk to the database.
+func (m *Migrator) apply(s *MigrationStatus) (err error) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"version":     s.Version,
+		"description": s.Description,
+	})
+
+	if len(s.Statements) == 0 {
+		return fmt.Errorf(
+			"Migration %d has no statements for driver %q", s.Version, m.driver,
+		)
+	}
+
+	logEntry.Info("Applying migration")
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return
+	}
+
+	for _, statement := range s.Statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d failed: %v", s.Version, err)
+		}
+	}
+
+	appliedAt := time.Now()
+	if err = m.versions.insertVersion(
+		tx, s.Version, s.Description, appliedAt,
+	); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	s.Applied = true
+	s.AppliedAt = appliedAt
+
+	logEntry.Debug("Migration applied")
+
+	return
+}
+
+// appliedVersions retrieves the versions of the migrations that have been
+// applied to the database, along with the time at which each of them was
+// applied. If no migration has been recorded, the migrations already applied
+// are detected from the database's schema.
+// Returns an error if we couldn't talk to the database.
+func (m *Migrator) appliedVersions() (map[int]time.Time, error) {
+	applied, err := m.versions.selectVersions()
+	if err != nil || len(applied) > 0 {
+		return applied, err
+	}
+
+	for version := 1; version <= m.detectBaseline(); version++ {
+		applied[version] = time.Time{}
+	}
+
+	return applied, nil
+}
+
+// detectBaseline returns the version of the schema of a database the bot used
+// before it recorded the migrations it applies. The proposals' data was already
+// keyed on their repository in some of these databases, in which case the
+// first two migrations are already applied. Otherwise, the first migration
+// only creates the tables that don't exist yet and can safely be applied.
+func (m *Migrator) detectBaseline() int {
+	rows, err := m.db.Query("SELECT * FROM proposal_state LIMIT 0")
+	if err != nil {
+		// The table doesn't exist.
+		return 0
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0
+	}
+
+	for _, column := range columns {
+		if column == "repository" {
+			return 2
+		}
+	}
+
+	return 0
+}
+
+// recordBaseline records the migrations in the given statuses that have been
+// detected as applied from the database's schema, so they're still considered
+// applied once newer migrations have been recorded.
+// Returns an error if we couldn't talk to the database.
+func (m *Migrator) recordBaseline(statuses []MigrationStatus) (err error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return
+	}
+
+	for _, s := range statuses {
+		if !s.Applied || !s.AppliedAt.IsZero() {
+			continue
+		}
+
+		logrus.WithField("version", s.Version).Info("Recording migration already applied")
+		if err = m.versions.insertVersion(
+			tx, s.Version, s.Description, time.Now(),
+		); err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The schema of the notices table is defined by the migrations in migrations.go.
+
+const insertNoticeSQL = `
+	INSERT INTO notices (repository, number, room_id, event_id, body, type, state, sent_at, replaces, editable)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+const selectLatestOriginalNoticeSQL = `
+	SELECT event_id FROM notices
+	WHERE repository = $1 AND number = $2 AND room_id = $3 AND replaces = ''
+	ORDER BY sent_at DESC LIMIT 1
+`
+
+const selectLatestEditableNoticeSQL = `
+	SELECT event_id FROM notices
+	WHERE repository = $1 AND number = $2 AND room_id = $3 AND replaces = ''
+	AND editable = TRUE
+	ORDER BY sent_at DESC LIMIT 1
+`
+
+const selectLatestNoticeBodySQL = `
+	SELECT body FROM notices
+	WHERE repository = $1 AND number = $2 AND room_id = $3
+	ORDER BY sent_at DESC LIMIT 1
+`
+
+type noticesStatements struct {
+	insertNoticeStmt               *sql.Stmt
+	selectLatestOriginalNoticeStmt *sql.Stmt
+	selectLatestEditableNoticeStmt *sql.Stmt
+	selectLatestNoticeBodyStmt     *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (ns *noticesStatements) prepare(db *sql.DB) (err error) {
+	if ns.insertNoticeStmt, err = db.Prepare(insertNoticeSQL); err != nil {
+		return
+	}
+	if ns.selectLatestOriginalNoticeStmt, err = db.Prepare(selectLatestOriginalNoticeSQL); err != nil {
+		return
+	}
+	if ns.selectLatestEditableNoticeStmt, err = db.Prepare(selectLatestEditableNoticeSQL); err != nil {
+		return
+	}
+	if ns.selectLatestNoticeBodyStmt, err = db.Prepare(selectLatestNoticeBodySQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertNotice records a notice that has been sent to a Matrix room, along with
+// the current time.
+// Returns an error if we couldn't talk to the database.
+func (ns *noticesStatements) insertNotice(
+	repository string, number int64, roomID string, eventID string, body string, scsType string,
+	state string, replaces string, editable bool,
+) error {
+	_, err := ns.insertNoticeStmt.Exec(
+		repository, number, roomID, eventID, body, scsType, state,
+		toMillis(time.Now()), replaces, editable,
+	)
+	return err
+}
+
+// selectLatestOriginalNotice retrieves the ID of the latest event sent to a
+// given room for a given proposal that isn't an edit of another event. Returns
+// an empty string and false if no such event exists.
+// Returns an error if we couldn't talk to the database.
+func (ns *noticesStatements) selectLatestOriginalNotice(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	var eventID string
+
+	err := ns.selectLatestOriginalNoticeStmt.QueryRow(repository, number, roomID).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return eventID, true, nil
+}
+
+// selectLatestEditableNotice retrieves the ID of the latest event sent to a
+// given room for a given proposal that can be edited by later updates and isn't
+// an edit of another event. Returns an empty string and false if no such event
+// exists.
+// Returns an error if we couldn't talk to the database.
+func (ns *noticesStatements) selectLatestEditableNotice(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	var eventID string
+
+	err := ns.selectLatestEditableNoticeStmt.QueryRow(repository, number, roomID).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return eventID, true, nil
+}
+
+// selectLatestNoticeBody retrieves the body of the latest notice (including
+// edits) sent to a given room for a given proposal. Returns an empty string and
+// false if no notice has been sent to this room for this proposal yet.
+// Returns an error if we couldn't talk to the database.
+func (ns *noticesStatements) selectLatestNoticeBody(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	var body string
+
+	err := ns.selectLatestNoticeBodyStmt.QueryRow(repository, number, roomID).Scan(&body)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return body, true, nil
+}
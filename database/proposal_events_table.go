@@ -0,0 +1,235 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+// The schema of the proposal_events table is defined by the migrations in migrations.go.
+
+const selectNextSeqSQL = `
+	SELECT COALESCE(MAX(seq) + 1, 0) FROM proposal_events
+	WHERE repository = $1 AND number = $2
+`
+
+const insertEventSQL = `
+	INSERT INTO proposal_events (
+		repository, number, seq, occurred_at, actor, event, action,
+		labels_added, labels_removed, type, state, notice_sent, delivery_id
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+`
+
+const selectEventsSQL = `
+	SELECT repository, number, occurred_at, actor, event, action, labels_added,
+		labels_removed, type, state, notice_sent, delivery_id
+	FROM proposal_events WHERE repository = $1 AND number = $2
+	ORDER BY seq ASC
+`
+
+const selectRepositoryEventsSQL = `
+	SELECT repository, number, occurred_at, actor, event, action, labels_added,
+		labels_removed, type, state, notice_sent, delivery_id
+	FROM proposal_events WHERE LOWER(repository) = LOWER($1)
+	ORDER BY number ASC, seq ASC
+`
+
+const selectDeliveryEventSQL = `
+	SELECT repository, number, occurred_at, actor, event, action, labels_added,
+		labels_removed, type, state, notice_sent, delivery_id
+	FROM proposal_events
+	WHERE repository = $1 AND number = $2 AND delivery_id = $3
+`
+
+const selectLatestStateSQL = `
+	SELECT state FROM proposal_events
+	WHERE repository = $1 AND number = $2 AND state <> ''
+	ORDER BY seq DESC LIMIT 1
+`
+
+type proposalEventsStatements struct {
+	selectNextSeqStmt          *sql.Stmt
+	insertEventStmt            *sql.Stmt
+	selectEventsStmt           *sql.Stmt
+	selectRepositoryEventsStmt *sql.Stmt
+	selectDeliveryEventStmt    *sql.Stmt
+	selectLatestStateStmt      *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (es *proposalEventsStatements) prepare(db *sql.DB) (err error) {
+	if es.selectNextSeqStmt, err = db.Prepare(selectNextSeqSQL); err != nil {
+		return
+	}
+	if es.insertEventStmt, err = db.Prepare(insertEventSQL); err != nil {
+		return
+	}
+	if es.selectEventsStmt, err = db.Prepare(selectEventsSQL); err != nil {
+		return
+	}
+	if es.selectRepositoryEventsStmt, err = db.Prepare(selectRepositoryEventsSQL); err != nil {
+		return
+	}
+	if es.selectDeliveryEventStmt, err = db.Prepare(selectDeliveryEventSQL); err != nil {
+		return
+	}
+	if es.selectLatestStateStmt, err = db.Prepare(selectLatestStateSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertEvent appends an event to the history of a proposal, as part of the
+// given transaction. Does nothing if an event from the same webhook delivery
+// has already been recorded for this proposal.
+// Returns an error if we couldn't talk to the database.
+func (es *proposalEventsStatements) insertEvent(
+	tx *sql.Tx, event types.ProposalEvent,
+) (err error) {
+	if len(event.DeliveryID) > 0 {
+		var rows *sql.Rows
+		if rows, err = tx.Stmt(es.selectDeliveryEventStmt).Query(
+			event.Repository, event.Number, event.DeliveryID,
+		); err != nil {
+			return
+		}
+
+		recorded := rows.Next()
+		rows.Close()
+		if recorded {
+			return
+		}
+	}
+
+	var seq int64
+	if err = tx.Stmt(es.selectNextSeqStmt).QueryRow(
+		event.Repository, event.Number,
+	).Scan(&seq); err != nil {
+		return
+	}
+
+	added, err := encodeLabels(event.LabelsAdded)
+	if err != nil {
+		return
+	}
+	removed, err := encodeLabels(event.LabelsRemoved)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Stmt(es.insertEventStmt).Exec(
+		event.Repository, event.Number, seq, toMillis(event.OccurredAt),
+		event.Actor, event.Event, event.Action, added, removed, event.Type,
+		event.State, event.NoticeSent, event.DeliveryID,
+	)
+	return
+}
+
+// selectEvents retrieves the history of a proposal, oldest event first.
+// Returns an error if we couldn't talk to the database.
+func (es *proposalEventsStatements) selectEvents(
+	repository string, number int64,
+) ([]types.ProposalEvent, error) {
+	rows, err := es.selectEventsStmt.Query(repository, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// selectRepositoryEvents retrieves the history of every proposal of a
+// repository, which name is matched case-insensitively, ordered by proposal
+// then oldest event first.
+// Returns an error if we couldn't talk to the database.
+func (es *proposalEventsStatements) selectRepositoryEvents(
+	repository string,
+) ([]types.ProposalEvent, error) {
+	rows, err := es.selectRepositoryEventsStmt.Query(repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// selectDeliveryEvent retrieves the event of a proposal which comes from a given
+// webhook delivery. Returns false if no such event has been recorded.
+// Returns an error if we couldn't talk to the database.
+func (es *proposalEventsStatements) selectDeliveryEvent(
+	repository string, number int64, deliveryID string,
+) (types.ProposalEvent, bool, error) {
+	rows, err := es.selectDeliveryEventStmt.Query(repository, number, deliveryID)
+	if err != nil {
+		return types.ProposalEvent{}, false, err
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil || len(events) == 0 {
+		return types.ProposalEvent{}, false, err
+	}
+
+	return events[0], true, nil
+}
+
+// selectLatestState retrieves the latest non-empty SCSP state recorded in the
+// history of a proposal. Returns false if no event recorded for this proposal
+// has a SCSP state.
+// Returns an error if we couldn't talk to the database.
+func (es *proposalEventsStatements) selectLatestState(
+	repository string, number int64,
+) (state string, ok bool, err error) {
+	err = es.selectLatestStateStmt.QueryRow(repository, number).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+
+	return state, err == nil, err
+}
+
+// scanEvents reads the proposal events from the given rows, which columns must
+// be the ones selected by selectEventsSQL, selectRepositoryEventsSQL and
+// selectDeliveryEventSQL.
+// Returns an error if we couldn't talk to the database or decode the labels.
+func scanEvents(rows *sql.Rows) ([]types.ProposalEvent, error) {
+	events := make([]types.ProposalEvent, 0)
+	for rows.Next() {
+		var event types.ProposalEvent
+		var occurredAt int64
+		var added, removed string
+		if err := rows.Scan(
+			&event.Repository, &event.Number, &occurredAt, &event.Actor,
+			&event.Event, &event.Action, &added, &removed, &event.Type,
+			&event.State, &event.NoticeSent, &event.DeliveryID,
+		); err != nil {
+			return nil, err
+		}
+
+		event.OccurredAt = fromMillis(occurredAt)
+		if err := json.Unmarshal([]byte(added), &event.LabelsAdded); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(removed), &event.LabelsRemoved); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// encodeLabels encodes the given list of labels into JSON, encoding a nil list
+// as an empty one.
+func encodeLabels(labels []string) (string, error) {
+	if labels == nil {
+		labels = []string{}
+	}
+
+	b, err := json.Marshal(labels)
+	return string(b), err
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// The schema of the proposal_labels table is defined by the migrations in migrations.go.
+
+const deleteLabelsSQL = `
+	DELETE FROM proposal_labels WHERE repository = $1 AND number = $2
+`
+
+const insertLabelSQL = `
+	INSERT INTO proposal_labels (repository, number, label, ordinal)
+	VALUES ($1, $2, $3, $4)
+`
+
+const selectLabelsSQL = `
+	SELECT label FROM proposal_labels WHERE repository = $1 AND number = $2
+	ORDER BY ordinal ASC
+`
+
+const selectNumbersWithLabelSQL = `
+	SELECT number FROM proposal_labels WHERE repository = $1 AND label = $2
+	ORDER BY number ASC
+`
+
+type proposalLabelsStatements struct {
+	deleteLabelsStmt           *sql.Stmt
+	insertLabelStmt            *sql.Stmt
+	selectLabelsStmt           *sql.Stmt
+	selectNumbersWithLabelStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (ps *proposalLabelsStatements) prepare(db *sql.DB) (err error) {
+	if ps.deleteLabelsStmt, err = db.Prepare(deleteLabelsSQL); err != nil {
+		return
+	}
+	if ps.insertLabelStmt, err = db.Prepare(insertLabelSQL); err != nil {
+		return
+	}
+	if ps.selectLabelsStmt, err = db.Prepare(selectLabelsSQL); err != nil {
+		return
+	}
+	if ps.selectNumbersWithLabelStmt, err = db.Prepare(selectNumbersWithLabelSQL); err != nil {
+		return
+	}
+	return
+}
+
+// replaceLabels replaces the labels of a proposal with the given ones, as part
+// of the given transaction. Labels appearing more than once are only saved
+// once.
+// Returns an error if we couldn't talk to the database.
+func (ps *proposalLabelsStatements) replaceLabels(
+	tx *sql.Tx, repository string, number int64, labels []string,
+) error {
+	if _, err := tx.Stmt(ps.deleteLabelsStmt).Exec(repository, number); err != nil {
+		return err
+	}
+
+	insertStmt := tx.Stmt(ps.insertLabelStmt)
+	saved := make(map[string]bool)
+	for i, label := range labels {
+		if saved[label] {
+			continue
+		}
+
+		if _, err := insertStmt.Exec(repository, number, label, i); err != nil {
+			return err
+		}
+		saved[label] = true
+	}
+
+	return nil
+}
+
+// selectLabels retrieves the labels of a proposal, in the order they were
+// saved in. Returns an empty slice if no label has been saved for this
+// proposal.
+// Returns an error if we couldn't talk to the database.
+func (ps *proposalLabelsStatements) selectLabels(
+	repository string, number int64,
+) ([]string, error) {
+	rows, err := ps.selectLabelsStmt.Query(repository, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make([]string, 0)
+	for rows.Next() {
+		var label string
+		if err = rows.Scan(&label); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+
+	return labels, rows.Err()
+}
+
+// selectNumbersWithLabel retrieves the numbers of the proposals of a repository
+// that carry a given label, in ascending order.
+// Returns an error if we couldn't talk to the database.
+func (ps *proposalLabelsStatements) selectNumbersWithLabel(
+	repository string, label string,
+) ([]int64, error) {
+	rows, err := ps.selectNumbersWithLabelStmt.Query(repository, label)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	numbers := make([]int64, 0)
+	for rows.Next() {
+		var number int64
+		if err = rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, rows.Err()
+}
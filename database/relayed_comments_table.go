@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The schema of the relayed_comments table is defined by the migrations in migrations.go.
+
+const insertRelayedCommentSQL = `
+	INSERT INTO relayed_comments (delivery_id, room_id, event_id, relayed_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (delivery_id, room_id) DO NOTHING
+`
+
+const selectRelayedCommentSQL = `
+	SELECT 1 FROM relayed_comments WHERE delivery_id = $1 AND room_id = $2
+`
+
+const deleteRelayedCommentsBeforeSQL = `
+	DELETE FROM relayed_comments WHERE relayed_at < $1
+`
+
+type relayedCommentsStatements struct {
+	insertRelayedCommentStmt        *sql.Stmt
+	selectRelayedCommentStmt        *sql.Stmt
+	deleteRelayedCommentsBeforeStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (rs *relayedCommentsStatements) prepare(db *sql.DB) (err error) {
+	if rs.insertRelayedCommentStmt, err = db.Prepare(insertRelayedCommentSQL); err != nil {
+		return
+	}
+	if rs.selectRelayedCommentStmt, err = db.Prepare(selectRelayedCommentSQL); err != nil {
+		return
+	}
+	if rs.deleteRelayedCommentsBeforeStmt, err = db.Prepare(deleteRelayedCommentsBeforeSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertRelayedComment records that the comment from a given webhook delivery
+// has been relayed to a given room, along with the current time. Does nothing
+// if it has already been recorded.
+// Returns an error if we couldn't talk to the database.
+func (rs *relayedCommentsStatements) insertRelayedComment(
+	deliveryID string, roomID string, eventID string,
+) error {
+	_, err := rs.insertRelayedCommentStmt.Exec(
+		deliveryID, roomID, eventID, toMillis(time.Now()),
+	)
+	return err
+}
+
+// isRelayedComment checks whether the comment from a given webhook delivery
+// has already been relayed to a given room.
+// Returns an error if we couldn't talk to the database.
+func (rs *relayedCommentsStatements) isRelayedComment(
+	deliveryID string, roomID string,
+) (bool, error) {
+	rows, err := rs.selectRelayedCommentStmt.Query(deliveryID, roomID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+// deleteRelayedCommentsBefore deletes the records of the comments relayed
+// before the given time, and returns the number of deleted records.
+// Returns an error if we couldn't talk to the database.
+func (rs *relayedCommentsStatements) deleteRelayedCommentsBefore(
+	before time.Time,
+) (int64, error) {
+	res, err := rs.deleteRelayedCommentsBeforeStmt.Exec(toMillis(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+// The schema of the reminders table is defined by the migrations in migrations.go.
+
+const deleteOtherReminderSQL = `
+	DELETE FROM reminders WHERE repository = $1 AND number = $2
+	AND (type <> $3 OR state <> $4)
+`
+
+const insertReminderSQL = `
+	INSERT INTO reminders (
+		repository, number, type, state, title, url, due_at, sent
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, FALSE)
+	ON CONFLICT (repository, number) DO NOTHING
+`
+
+const deleteReminderSQL = `
+	DELETE FROM reminders WHERE repository = $1 AND number = $2
+`
+
+const selectDueRemindersSQL = `
+	SELECT repository, number, type, state, title, url, due_at FROM reminders
+	WHERE sent = FALSE AND due_at <= $1
+	ORDER BY due_at ASC
+`
+
+const selectPendingReminderSQL = `
+	SELECT repository, number, type, state, title, url, due_at FROM reminders
+	WHERE repository = $1 AND number = $2 AND sent = FALSE
+`
+
+const updateReminderSentSQL = `
+	UPDATE reminders SET sent = TRUE
+	WHERE repository = $1 AND number = $2 AND type = $3 AND state = $4
+`
+
+type remindersStatements struct {
+	deleteOtherReminderStmt   *sql.Stmt
+	insertReminderStmt        *sql.Stmt
+	deleteReminderStmt        *sql.Stmt
+	selectDueRemindersStmt    *sql.Stmt
+	selectPendingReminderStmt *sql.Stmt
+	updateReminderSentStmt    *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (rs *remindersStatements) prepare(db *sql.DB) (err error) {
+	if rs.deleteOtherReminderStmt, err = db.Prepare(deleteOtherReminderSQL); err != nil {
+		return
+	}
+	if rs.insertReminderStmt, err = db.Prepare(insertReminderSQL); err != nil {
+		return
+	}
+	if rs.deleteReminderStmt, err = db.Prepare(deleteReminderSQL); err != nil {
+		return
+	}
+	if rs.selectDueRemindersStmt, err = db.Prepare(selectDueRemindersSQL); err != nil {
+		return
+	}
+	if rs.selectPendingReminderStmt, err = db.Prepare(selectPendingReminderSQL); err != nil {
+		return
+	}
+	if rs.updateReminderSentStmt, err = db.Prepare(updateReminderSentSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertReminder saves a reminder for a proposal, as part of the given
+// transaction. Any reminder previously saved for this proposal with another
+// type or SCSP state is deleted, and a reminder previously saved with the same
+// type and SCSP state is kept as it is, so its due date isn't pushed back.
+// Returns an error if we couldn't talk to the database.
+func (rs *remindersStatements) insertReminder(
+	tx *sql.Tx, reminder types.Reminder,
+) (err error) {
+	if _, err = tx.Stmt(rs.deleteOtherReminderStmt).Exec(
+		reminder.Repository, reminder.Number, reminder.Type, reminder.State,
+	); err != nil {
+		return
+	}
+
+	_, err = tx.Stmt(rs.insertReminderStmt).Exec(
+		reminder.Repository, reminder.Number, reminder.Type, reminder.State,
+		reminder.Title, reminder.URL, toMillis(reminder.DueAt),
+	)
+	return
+}
+
+// deleteReminder deletes the reminder saved for a proposal, if any.
+// Returns an error if we couldn't talk to the database.
+func (rs *remindersStatements) deleteReminder(
+	repository string, number int64,
+) error {
+	_, err := rs.deleteReminderStmt.Exec(repository, number)
+	return err
+}
+
+// selectDueReminders retrieves the reminders that haven't been sent and which
+// due date is before the given time, earliest first.
+// Returns an error if we couldn't talk to the database.
+func (rs *remindersStatements) selectDueReminders(
+	before time.Time,
+) ([]types.Reminder, error) {
+	rows, err := rs.selectDueRemindersStmt.Query(toMillis(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanReminders(rows)
+}
+
+// selectPendingReminder retrieves the reminder saved for a proposal if it
+// hasn't been sent yet. Returns false if there's no such reminder.
+// Returns an error if we couldn't talk to the database.
+func (rs *remindersStatements) selectPendingReminder(
+	repository string, number int64,
+) (types.Reminder, bool, error) {
+	rows, err := rs.selectPendingReminderStmt.Query(repository, number)
+	if err != nil {
+		return types.Reminder{}, false, err
+	}
+	defer rows.Close()
+
+	reminders, err := scanReminders(rows)
+	if err != nil || len(reminders) == 0 {
+		return types.Reminder{}, false, err
+	}
+
+	return reminders[0], true, nil
+}
+
+// scanReminders reads the reminders from the given rows, which columns must be
+// the ones selected by selectDueRemindersSQL and selectPendingReminderSQL.
+// Returns an error if we couldn't talk to the database.
+func scanReminders(rows *sql.Rows) ([]types.Reminder, error) {
+	reminders := make([]types.Reminder, 0)
+	for rows.Next() {
+		var reminder types.Reminder
+		var dueAt int64
+		if err := rows.Scan(
+			&reminder.Repository, &reminder.Number, &reminder.Type,
+			&reminder.State, &reminder.Title, &reminder.URL, &dueAt,
+		); err != nil {
+			return nil, err
+		}
+
+		reminder.DueAt = fromMillis(dueAt)
+		reminders = append(reminders, reminder)
+	}
+
+	return reminders, rows.Err()
+}
+
+// updateReminderSent marks the given reminder as sent. Does nothing if the
+// reminder saved for the proposal has since been replaced with one for another
+// type or SCSP state.
+// Returns an error if we couldn't talk to the database.
+func (rs *remindersStatements) updateReminderSent(reminder types.Reminder) error {
+	_, err := rs.updateReminderSentStmt.Exec(
+		reminder.Repository, reminder.Number, reminder.Type, reminder.State,
+	)
+	return err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Schema of the table. This table isn't created by a migration, as it's the
+// one recording which migrations have been applied.
+const schemaVersionSchema = `
+-- Store the migrations applied to the database's schema
+CREATE TABLE IF NOT EXISTS schema_version (
+	-- Version of the schema the migration upgrades the database to
+	version INTEGER PRIMARY KEY,
+	-- Description of the migration
+	description TEXT NOT NULL,
+	-- Timestamp (in milliseconds) at which the migration was applied
+	applied_at BIGINT NOT NULL
+);
+`
+
+const insertVersionSQL = `
+	INSERT INTO schema_version (version, description, applied_at) VALUES ($1, $2, $3)
+`
+
+const selectVersionsSQL = `
+	SELECT version, applied_at FROM schema_version
+`
+
+type schemaVersionStatements struct {
+	insertVersionStmt  *sql.Stmt
+	selectVersionsStmt *sql.Stmt
+}
+
+// Create the table if it doesn't exist and prepare the SQL statements.
+func (ss *schemaVersionStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(schemaVersionSchema)
+	if err != nil {
+		return
+	}
+	if ss.insertVersionStmt, err = db.Prepare(insertVersionSQL); err != nil {
+		return
+	}
+	if ss.selectVersionsStmt, err = db.Prepare(selectVersionsSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertVersion records a migration as applied at the given time, as part of
+// the given transaction.
+// Returns an error if we couldn't talk to the database.
+func (ss *schemaVersionStatements) insertVersion(
+	tx *sql.Tx, version int, description string, appliedAt time.Time,
+) error {
+	_, err := tx.Stmt(ss.insertVersionStmt).Exec(
+		version, description, toMillis(appliedAt),
+	)
+	return err
+}
+
+// selectVersions retrieves the versions of the migrations that have been
+// applied, along with the time at which each of them was applied.
+// Returns an error if we couldn't talk to the database.
+func (ss *schemaVersionStatements) selectVersions() (map[int]time.Time, error) {
+	rows, err := ss.selectVersionsStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = fromMillis(appliedAt)
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// The schema of the thread_roots table is defined by the migrations in migrations.go.
+
+const insertThreadRootSQL = `
+	INSERT INTO thread_roots (repository, number, room_id, event_id)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (repository, number, room_id) DO NOTHING
+`
+
+const selectThreadRootSQL = `
+	SELECT event_id FROM thread_roots
+	WHERE repository = $1 AND number = $2 AND room_id = $3
+`
+
+type threadRootsStatements struct {
+	insertThreadRootStmt *sql.Stmt
+	selectThreadRootStmt *sql.Stmt
+}
+
+// Prepare the SQL statements.
+func (ts *threadRootsStatements) prepare(db *sql.DB) (err error) {
+	if ts.insertThreadRootStmt, err = db.Prepare(insertThreadRootSQL); err != nil {
+		return
+	}
+	if ts.selectThreadRootStmt, err = db.Prepare(selectThreadRootSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertThreadRoot saves the root of the thread of a proposal in a room. Does
+// nothing if a root has already been saved for this proposal and room.
+// Returns an error if we couldn't talk to the database.
+func (ts *threadRootsStatements) insertThreadRoot(
+	repository string, number int64, roomID string, eventID string,
+) error {
+	_, err := ts.insertThreadRootStmt.Exec(repository, number, roomID, eventID)
+	return err
+}
+
+// selectThreadRoot retrieves the ID of the event at the root of the thread of a
+// proposal in a room. Returns an empty string and false if there's no thread
+// for this proposal in this room yet.
+// Returns an error if we couldn't talk to the database.
+func (ts *threadRootsStatements) selectThreadRoot(
+	repository string, number int64, roomID string,
+) (string, bool, error) {
+	var eventID string
+
+	err := ts.selectThreadRootStmt.QueryRow(repository, number, roomID).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return eventID, true, nil
+}
@@ -0,0 +1,127 @@
+package hook
+
+import (
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/mutex"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/webhooks.v5/github"
+)
+
+// HandleIssueCommentPayload processes the payload of an issue comment event
+// received by the GitHub webhook, which is sent for comments on both issues and
+// pull requests. If the event's action is "created", it relays the comment to
+// the rooms configured for comments. The given delivery ID is the GUID of the
+// webhook delivery the payload comes from, if known, which is used to avoid
+// relaying the comment to the same room twice if processing it is retried.
+// Returns and do nothing if the event's action isn't "created".
+// Returns with an error if the comment couldn't be relayed.
+func HandleIssueCommentPayload(
+	pl github.IssueCommentPayload, deliveryID string, cli *matrix.Cli,
+) (err error) {
+	repo := pl.Repository.FullName
+
+	logDebugEntry := logrus.WithFields(logrus.Fields{
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.Issue.Number,
+	})
+
+	logDebugEntry.Debug("Got issue comment event payload")
+
+	// Only process new comments.
+	if pl.Action != "created" {
+		logDebugEntry.Debug("Ignoring issue comment")
+		return nil
+	}
+
+	logDebugEntry.Debug("Processing issue comment")
+
+	issue := pl.Issue
+
+	// Lock the mutex for this proposal in order to make sure it doesn't get
+	// updated by another event before we're done with this one.
+	mutex.Lock(repo, issue.Number)
+
+	// Retrieve the labels' names.
+	labels := make([]string, 0)
+	for _, l := range issue.Labels {
+		labels = append(labels, l.Name)
+	}
+
+	err = cli.RelayComment(&types.CommentData{
+		Repository:        repo,
+		Number:            issue.Number,
+		Title:             issue.Title,
+		URL:               issue.HTMLURL,
+		Labels:            labels,
+		Author:            pl.Comment.User.Login,
+		AuthorAssociation: pl.Comment.AuthorAssociation,
+		Body:              pl.Comment.Body,
+		CommentURL:        pl.Comment.HTMLURL,
+		DeliveryID:        deliveryID,
+	})
+	return unlockAndReturnErr(repo, issue.Number, err)
+}
+
+// HandlePullRequestReviewCommentPayload processes the payload of a pull request
+// review comment event received by the GitHub webhook. If the event's action is
+// "created", it relays the comment to the rooms configured for comments. As
+// review comment payloads don't include the PR's labels, the labels saved in
+// the proposal's state are used instead. The given delivery ID is the GUID of
+// the webhook delivery the payload comes from, if known, which is used to
+// avoid relaying the comment to the same room twice if processing it is
+// retried.
+// Returns and do nothing if the event's action isn't "created".
+// Returns with an error if the proposal's state couldn't be retrieved or if the
+// comment couldn't be relayed.
+func HandlePullRequestReviewCommentPayload(
+	pl github.PullRequestReviewCommentPayload, deliveryID string,
+	cli *matrix.Cli, db *database.Database,
+) (err error) {
+	repo := pl.Repository.FullName
+
+	logDebugEntry := logrus.WithFields(logrus.Fields{
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.PullRequest.Number,
+	})
+
+	logDebugEntry.Debug("Got PR review comment event payload")
+
+	// Only process new comments.
+	if pl.Action != "created" {
+		logDebugEntry.Debug("Ignoring PR review comment")
+		return nil
+	}
+
+	logDebugEntry.Debug("Processing PR review comment")
+
+	pr := pl.PullRequest
+
+	// Lock the mutex for this proposal in order to make sure it doesn't get
+	// updated by another event before we're done with this one.
+	mutex.Lock(repo, pr.Number)
+
+	// Retrieve the labels' names from the proposal's state.
+	labels, err := db.GetProposalState(repo, pr.Number)
+	if err != nil {
+		return unlockAndReturnErr(repo, pr.Number, err)
+	}
+
+	err = cli.RelayComment(&types.CommentData{
+		Repository:        repo,
+		Number:            pr.Number,
+		Title:             pr.Title,
+		URL:               pr.HTMLURL,
+		Labels:            labels,
+		Author:            pl.Comment.User.Login,
+		AuthorAssociation: pl.Comment.AuthorAssociation,
+		Body:              pl.Comment.Body,
+		CommentURL:        pl.Comment.HTMLURL,
+		DeliveryID:        deliveryID,
+	})
+	return unlockAndReturnErr(repo, pr.Number, err)
+}
@@ -0,0 +1,92 @@
+package hook
+
+import (
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resolveConflicts completes the given conflicts between the labels of the
+// submission described by the given data with the given event which revealed
+// them. If the configured policy allows it, each conflict is resolved by
+// picking the given label added by the event, if it's one of the conflicting
+// labels, in which case the SCS data is filled with the type or SCSP state it
+// defines.
+// Returns whether every conflict could be resolved.
+func resolveConflicts(
+	data *types.SCSData, event *types.ProposalEvent,
+	conflicts []types.LabelConflict, added string, cfg *config.Config,
+	logDebugEntry *logrus.Entry,
+) (resolved bool) {
+	resolved = true
+	for i := range conflicts {
+		conflict := &conflicts[i]
+		conflict.Repository = event.Repository
+		conflict.Number = event.Number
+		conflict.OccurredAt = event.OccurredAt
+		conflict.Actor = event.Actor
+
+		if cfg.Conflicts.Policy == config.ConflictPolicyLatest &&
+			types.Contains(conflict.Labels, added) {
+			conflict.Resolution = added
+
+			_, value := labelGrammar(cfg, event.Repository).Match(added)
+			switch conflict.Kind {
+			case config.LabelKindType:
+				data.Type = value
+			case config.LabelKindState:
+				data.State = value
+			}
+		}
+
+		logDebugEntry.WithFields(logrus.Fields{
+			"kind":       conflict.Kind,
+			"labels":     conflict.Labels,
+			"resolution": conflict.Resolution,
+		}).Debug("Got conflicting labels")
+
+		resolved = resolved && len(conflict.Resolution) > 0
+	}
+
+	return
+}
+
+// reportConflicts reports the given conflicts between the labels of the
+// submission described by the given data to the admin room, and records them
+// in the database. Conflicts that have already been recorded, e.g. because they
+// persist across several events or because processing the event is retried,
+// are skipped, so each distinct conflict is only reported once.
+// Returns with an error if a conflict couldn't be looked up, reported or
+// recorded.
+func reportConflicts(
+	data *types.SCSData, conflicts []types.LabelConflict, cli *matrix.Cli,
+	db *database.Database, logDebugEntry *logrus.Entry,
+) (err error) {
+	for _, conflict := range conflicts {
+		var recorded bool
+		if recorded, err = db.HasLabelConflict(conflict); err != nil {
+			return
+		}
+
+		if recorded {
+			logDebugEntry.WithFields(logrus.Fields{
+				"kind":   conflict.Kind,
+				"labels": conflict.Labels,
+			}).Debug("Conflict already reported")
+			continue
+		}
+
+		if err = cli.ReportLabelConflict(data, conflict); err != nil {
+			return
+		}
+
+		if err = db.RecordLabelConflict(conflict); err != nil {
+			return
+		}
+	}
+
+	return
+}
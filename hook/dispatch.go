@@ -0,0 +1,101 @@
+package hook
+
+import (
+	"encoding/json"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/reminder"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/webhooks.v5/github"
+)
+
+// HandledEvents lists the GitHub events the webhook processes.
+var HandledEvents = []github.Event{
+	github.PullRequestEvent,
+	github.IssuesEvent,
+	github.PullRequestReviewEvent,
+	github.IssueCommentEvent,
+	github.PullRequestReviewCommentEvent,
+}
+
+// draftPayload holds the draft status of the pull request a pull request or
+// pull request review event is about, which the webhooks library doesn't
+// decode.
+type draftPayload struct {
+	PullRequest struct {
+		Draft bool `json:"draft"`
+	} `json:"pull_request"`
+}
+
+// HandlePayload decodes the JSON-encoded payload of a given GitHub event and
+// calls the handler for this event with it. The given delivery ID is the GUID
+// of the webhook delivery the payload comes from, if known, which is used to
+// avoid recording the same event twice if processing it is retried.
+// Returns and do nothing if the event isn't one of the handled events.
+// Returns with an error if the payload couldn't be decoded or if the handler
+// returned with an error.
+func HandlePayload(
+	event string, deliveryID string, payload []byte, cfg *config.Config,
+	cli *matrix.Cli, db *database.Database, rem *reminder.Scheduler,
+) (err error) {
+	switch github.Event(event) {
+	case github.PullRequestEvent:
+		var pl github.PullRequestPayload
+		var draft draftPayload
+		if err = unmarshalPullRequestPayload(payload, &pl, &draft); err != nil {
+			return
+		}
+		return HandlePullRequestPayload(
+			pl, draft.PullRequest.Draft, deliveryID, cfg, cli, db, rem,
+		)
+	case github.IssuesEvent:
+		var pl github.IssuesPayload
+		if err = json.Unmarshal(payload, &pl); err != nil {
+			return
+		}
+		return HandleIssuesPayload(pl, deliveryID, cfg, cli, db, rem)
+	case github.PullRequestReviewEvent:
+		var pl github.PullRequestReviewPayload
+		var draft draftPayload
+		if err = unmarshalPullRequestPayload(payload, &pl, &draft); err != nil {
+			return
+		}
+		return HandlePullRequestReviewPayload(
+			pl, draft.PullRequest.Draft, deliveryID, cfg, cli, db,
+		)
+	case github.IssueCommentEvent:
+		var pl github.IssueCommentPayload
+		if err = json.Unmarshal(payload, &pl); err != nil {
+			return
+		}
+		return HandleIssueCommentPayload(pl, deliveryID, cli)
+	case github.PullRequestReviewCommentEvent:
+		var pl github.PullRequestReviewCommentPayload
+		if err = json.Unmarshal(payload, &pl); err != nil {
+			return
+		}
+		return HandlePullRequestReviewCommentPayload(pl, deliveryID, cli, db)
+	}
+
+	logrus.WithField("event", event).Debug("Ignoring payload for unhandled event")
+
+	return nil
+}
+
+// unmarshalPullRequestPayload decodes the JSON-encoded payload of a pull
+// request or pull request review event into the given payload structure from
+// the webhooks library, and the draft status of the pull request into the
+// given draftPayload.
+// Returns with an error if the payload couldn't be decoded.
+func unmarshalPullRequestPayload(
+	payload []byte, pl interface{}, draft *draftPayload,
+) (err error) {
+	if err = json.Unmarshal(payload, pl); err != nil {
+		return
+	}
+
+	return json.Unmarshal(payload, draft)
+}
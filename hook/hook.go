@@ -1,11 +1,13 @@
 package hook
 
 import (
-	"strings"
+	"sort"
 
+	"github.com/Informo/specs-bot/config"
 	"github.com/Informo/specs-bot/database"
 	"github.com/Informo/specs-bot/matrix"
 	"github.com/Informo/specs-bot/mutex"
+	"github.com/Informo/specs-bot/reminder"
 	"github.com/Informo/specs-bot/types"
 
 	"github.com/sirupsen/logrus"
@@ -17,32 +19,91 @@ import (
 // (i.e. "(un)labeled"), it extracts the PR's labels' names and calls
 // handleSubmission with the list of names and some specific data regarding the
 // PR, which will then process the extracted data and trigger the generation
-// and sending of a notice to the Matrix rooms.
-// Returns and do nothing if the event's action isn't related to labels, or if
-// handleSubmission (or subsequent function calls) decided there was no need to
-// send a notice out.
-// Returns with an error if handleSubmission or any subsequent function call
-// returned with an error.
+// and sending of a notice to the Matrix rooms. If the event's action is
+// related to the PR's lifecycle (e.g. "opened" or "closed"), it calls
+// handleLifecycleEvent instead. The given draft status is the PR's, which the
+// webhooks library doesn't decode, and the given delivery ID is the GUID of
+// the webhook delivery the payload comes from, if known.
+// Returns and do nothing if the event's action isn't related to labels or to
+// the PR's lifecycle, or if handleSubmission (or subsequent function calls)
+// decided there was no need to send a notice out.
+// Returns with an error if handleSubmission, handleLifecycleEvent or any
+// subsequent function call returned with an error.
 func HandlePullRequestPayload(
-	pl github.PullRequestPayload, cli *matrix.Cli, db *database.Database,
+	pl github.PullRequestPayload, draft bool, deliveryID string,
+	cfg *config.Config, cli *matrix.Cli, db *database.Database,
+	rem *reminder.Scheduler,
 ) (err error) {
+	repo := pl.Repository.FullName
+
 	logrus.WithFields(logrus.Fields{
-		"action": pl.Action,
-		"number": pl.PullRequest.Number,
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.PullRequest.Number,
 	}).Debug("Got PR event payload")
 
 	// Only process the label-related action.
 	if pl.Action == "labeled" || pl.Action == "unlabeled" {
 		logrus.WithFields(logrus.Fields{
-			"action": pl.Action,
-			"number": pl.PullRequest.Number,
+			"action":     pl.Action,
+			"repository": repo,
+			"number":     pl.PullRequest.Number,
 		}).Debug("Processing PR")
 
 		pr := pl.PullRequest
 
 		// Lock the mutex for this proposal in order to make sure it doesn't get
 		// updated by another event before we're done with this one.
-		mutex.Lock(pr.Number)
+		mutex.Lock(repo, pr.Number)
+
+		// Retrieve the labels' names.
+		labels := make([]string, 0)
+		for _, l := range pr.Labels {
+			labels = append(labels, l.Name)
+		}
+
+		event := &types.ProposalEvent{
+			Repository: repo,
+			Number:     pr.Number,
+			OccurredAt: pr.UpdatedAt,
+			Actor:      pl.Sender.Login,
+			Event:      string(github.PullRequestEvent),
+			Action:     pl.Action,
+			DeliveryID: deliveryID,
+		}
+
+		data := &types.SCSData{
+			Repository: repo,
+			Number:     pr.Number,
+			Title:      pr.Title,
+			URL:        pr.HTMLURL,
+			Labels:     labels,
+			Actor:      pl.Sender.Login,
+			Author:     pr.User.Login,
+			Draft:      draft,
+			Closed:     pr.State == "closed",
+		}
+
+		added, removed := changedLabel(pl.Action, pl.Label.Name)
+
+		err = handleSubmission(event, data, added, removed, cfg, cli, db, rem)
+		return unlockAndReturnErr(repo, pr.Number, err)
+	}
+
+	// Process the actions related to the PR's lifecycle.
+	if lifecycle, ok := lifecycleEvent(pl); ok {
+		logrus.WithFields(logrus.Fields{
+			"action":     pl.Action,
+			"event":      lifecycle,
+			"repository": repo,
+			"number":     pl.PullRequest.Number,
+		}).Debug("Processing PR lifecycle event")
+
+		pr := pl.PullRequest
+
+		// Lock the mutex for this proposal in order to make sure it doesn't get
+		// updated by another event before we're done with this one.
+		mutex.Lock(repo, pr.Number)
 
 		// Retrieve the labels' names.
 		labels := make([]string, 0)
@@ -50,13 +111,36 @@ func HandlePullRequestPayload(
 			labels = append(labels, l.Name)
 		}
 
-		err = handleSubmission(pr.Number, pr.Title, pr.HTMLURL, labels, cli, db)
-		return unlockAndReturnErr(pr.Number, err)
+		event := &types.ProposalEvent{
+			Repository: repo,
+			Number:     pr.Number,
+			OccurredAt: pr.UpdatedAt,
+			Actor:      pl.Sender.Login,
+			Event:      string(github.PullRequestEvent),
+			Action:     lifecycle,
+			DeliveryID: deliveryID,
+		}
+
+		data := &types.SCSData{
+			Repository: repo,
+			Number:     pr.Number,
+			Title:      pr.Title,
+			URL:        pr.HTMLURL,
+			Labels:     labels,
+			Actor:      pl.Sender.Login,
+			Author:     pr.User.Login,
+			Draft:      draft,
+			Closed:     pr.State == "closed",
+		}
+
+		err = handleLifecycleEvent(event, data, cfg, cli, db, rem)
+		return unlockAndReturnErr(repo, pr.Number, err)
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"action": pl.Action,
-		"number": pl.PullRequest.Number,
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.PullRequest.Number,
 	}).Debug("Ignoring PR")
 
 	return nil
@@ -67,32 +151,39 @@ func HandlePullRequestPayload(
 // "(un)labeled"), it extracts the issue's labels' names and calls
 // handleSubmission with the list of names and some specific data regarding the
 // issue, which will then process the extracted data and trigger the generation
-// and sending of a notice to the Matrix rooms.
-// Returns and do nothing if the event's action isn't related to labels, or if
-// handleSubmission (or subsequent function calls) decided there was no need to
-// send a notice out.
-// Returns with an error if handleSubmission or any subsequent function call
-// returned with an error.
+// and sending of a notice to the Matrix rooms. If the issue has been closed or
+// reopened, it calls handleLifecycleEvent instead. The given delivery ID is the
+// GUID of the webhook delivery the payload comes from, if known.
+// Returns and do nothing if the event's action isn't related to labels or to
+// the issue being closed or reopened, or if handleSubmission (or subsequent
+// function calls) decided there was no need to send a notice out.
+// Returns with an error if handleSubmission, handleLifecycleEvent or any
+// subsequent function call returned with an error.
 func HandleIssuesPayload(
-	pl github.IssuesPayload, cli *matrix.Cli, db *database.Database,
+	pl github.IssuesPayload, deliveryID string, cfg *config.Config,
+	cli *matrix.Cli, db *database.Database, rem *reminder.Scheduler,
 ) (err error) {
+	repo := pl.Repository.FullName
+
 	logrus.WithFields(logrus.Fields{
-		"action": pl.Action,
-		"number": pl.Issue.Number,
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.Issue.Number,
 	}).Debug("Got issue event payload")
 
 	// Only process the label-related actions.
 	if pl.Action == "labeled" || pl.Action == "unlabeled" {
 		logrus.WithFields(logrus.Fields{
-			"action": pl.Action,
-			"number": pl.Issue.Number,
+			"action":     pl.Action,
+			"repository": repo,
+			"number":     pl.Issue.Number,
 		}).Debug("Processing issue")
 
 		issue := pl.Issue
 
 		// Lock the mutex for this proposal in order to make sure it doesn't get
 		// updated by another event before we're done with this one.
-		mutex.Lock(issue.Number)
+		mutex.Lock(repo, issue.Number)
 
 		// Retrieve the labels' names.
 		labels := make([]string, 0)
@@ -100,160 +191,481 @@ func HandleIssuesPayload(
 			labels = append(labels, l.Name)
 		}
 
-		err = handleSubmission(
-			issue.Number, issue.Title, issue.HTMLURL, labels, cli, db,
-		)
-		return unlockAndReturnErr(issue.Number, err)
+		event := &types.ProposalEvent{
+			Repository: repo,
+			Number:     issue.Number,
+			OccurredAt: issue.UpdatedAt,
+			Actor:      pl.Sender.Login,
+			Event:      string(github.IssuesEvent),
+			Action:     pl.Action,
+			DeliveryID: deliveryID,
+		}
+
+		// Retrieve the name of the label added or removed by the event.
+		var label string
+		if pl.Label != nil {
+			label = pl.Label.Name
+		}
+		added, removed := changedLabel(pl.Action, label)
+
+		data := &types.SCSData{
+			Repository: repo,
+			Number:     issue.Number,
+			Title:      issue.Title,
+			URL:        issue.HTMLURL,
+			Labels:     labels,
+			Actor:      pl.Sender.Login,
+			Author:     issue.User.Login,
+			Closed:     issue.State == "closed",
+		}
+
+		err = handleSubmission(event, data, added, removed, cfg, cli, db, rem)
+		return unlockAndReturnErr(repo, issue.Number, err)
+	}
+
+	// Process the actions related to the issue's lifecycle.
+	if pl.Action == "closed" || pl.Action == "reopened" {
+		logrus.WithFields(logrus.Fields{
+			"action":     pl.Action,
+			"repository": repo,
+			"number":     pl.Issue.Number,
+		}).Debug("Processing issue lifecycle event")
+
+		issue := pl.Issue
+
+		// Lock the mutex for this proposal in order to make sure it doesn't get
+		// updated by another event before we're done with this one.
+		mutex.Lock(repo, issue.Number)
+
+		// Retrieve the labels' names.
+		labels := make([]string, 0)
+		for _, l := range issue.Labels {
+			labels = append(labels, l.Name)
+		}
+
+		event := &types.ProposalEvent{
+			Repository: repo,
+			Number:     issue.Number,
+			OccurredAt: issue.UpdatedAt,
+			Actor:      pl.Sender.Login,
+			Event:      string(github.IssuesEvent),
+			Action:     pl.Action,
+			DeliveryID: deliveryID,
+		}
+
+		data := &types.SCSData{
+			Repository: repo,
+			Number:     issue.Number,
+			Title:      issue.Title,
+			URL:        issue.HTMLURL,
+			Labels:     labels,
+			Actor:      pl.Sender.Login,
+			Author:     issue.User.Login,
+			Closed:     issue.State == "closed",
+		}
+
+		err = handleLifecycleEvent(event, data, cfg, cli, db, rem)
+		return unlockAndReturnErr(repo, issue.Number, err)
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"action": pl.Action,
-		"number": pl.Issue.Number,
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.Issue.Number,
 	}).Debug("Ignoring issue")
 
 	return nil
 }
 
-// handleSubmission uses the given data referring to a submission to decide
-// which workflow to use for the generation and sending of a Matrix notice for
-// this submission update. It implements bot the Informo SCSP
+// handleSubmission uses the given data referring to a submission to decide,
+// using the configured notice rules, whether and how a Matrix notice must be
+// sent for this submission update, and sends it. The default notice rules
+// implement both the Informo SCSP
 // (https://specs.informo.network/introduction/scsp/) and a generic workflow
-// which should work with most GitHub-driven submission workflow.
-// Return and do nothing if there's too much information (i.e. more than one
-// matching label name) for the submission's type or SCSP state, as we don't
-// know what to do in this case (and the safer way to handle it is to do
+// which should work with most GitHub-driven submission workflow. The given
+// added and removed labels are the labels added or removed by the event, if
+// any. It then saves the submission's new state, and records the given event,
+// completed with the changes in the submission's labels and whether a notice
+// was sent, in the submission's history, and schedules or cancels its reminder
+// accordingly.
+// If there's too much information (i.e. more than one matching label name) for
+// the submission's type or SCSP state, the conflict is resolved using the
+// given label added by the event if the configured policy allows it, then
+// reported and recorded after the notice has been sent, unless it already has
+// been. Doesn't send any notice if the conflict couldn't be resolved, as we
+// don't know what to do in this case (and the safer way to handle it is to do
 // nothing).
-// Returns with an error if either the Informo specific workflow or the generic
-// one returns with an error.
+// Returns with an error if the notice couldn't be sent, if the submission's
+// state couldn't be retrieved or saved, if its reminder couldn't be updated,
+// or if a conflict between its labels couldn't be reported or recorded.
 func handleSubmission(
-	number int64, title string, url string, labels []string, cli *matrix.Cli,
-	db *database.Database,
+	event *types.ProposalEvent, data *types.SCSData, added string,
+	removed string, cfg *config.Config, cli *matrix.Cli, db *database.Database,
+	rem *reminder.Scheduler,
 ) (err error) {
+	repository, number, labels := data.Repository, data.Number, data.Labels
+
 	logDebugEntry := logrus.WithFields(logrus.Fields{
-		"number": number,
-		"title":  title,
-		"url":    url,
-		"labels": labels,
+		"repository": repository,
+		"number":     number,
+		"title":      data.Title,
+		"url":        data.URL,
+		"labels":     labels,
 	})
 
 	logDebugEntry.Debug("Handling submission")
 
-	data := &types.SCSData{
-		Number: number,
-		Title:  title,
-		URL:    url,
+	// If a previous attempt at processing this event got as far as recording
+	// it, only its reminder is left to update.
+	if done, err := resumeRecordedEvent(event, data, db, rem); err != nil || done {
+		return err
+	}
+
+	// Retrieve the proposal's state, i.e. its labels before the event
+	// happened.
+	state, err := getState(db, repository, number)
+	if err != nil {
+		return
 	}
+	event.LabelsAdded, event.LabelsRemoved = diffLabels(state, labels)
 
-	unsplittableLabels := []string{}
+	grammar := labelGrammar(cfg, repository)
+	conflicts := parseLabels(data, labels, grammar, logDebugEntry)
 
-	var l string
-	for _, l = range labels {
-		// All labels defined in the Informo SCSP follow the form "xxx:yyy",
-		// such as "xxx" is the type of information held by the label, and yyy
-		// is that information.
-		split := strings.Split(l, ":")
-
-		// If the label name couldn't be split, store it in a slice that will be
-		// used if either the submission's type or state couldn't be determined,
-		// and skip to the next iteration (as there's not enough data to
-		// determine a specific type or state from this label name).
-		if len(split) < 2 {
-			logDebugEntry.WithField("name", l).Debug("Label name couldn't be split")
-			unsplittableLabels = append(unsplittableLabels, l)
-			continue
+	// If there's too much information for the submission's type or SCSP
+	// state, try to resolve the conflict.
+	ok := len(conflicts) == 0
+	if !ok {
+		ok = resolveConflicts(
+			data, event, conflicts, added, cfg, logDebugEntry,
+		)
+	}
+
+	if ok {
+		// Redefine the log entry's fields to append the type and state now
+		// that we have both of them in their definite state (i.e. their finite
+		// value or we know one or more haven't been provided).
+		logDebugEntry = logDebugEntry.WithFields(logrus.Fields{
+			"type":  data.Type,
+			"state": data.State,
+		})
+
+		// Determine the submission's previous SCSP state, so the rules can
+		// check its transition to the current one.
+		var from string
+		var known bool
+		if from, known, err = previousState(
+			db, repository, number, state, grammar, logDebugEntry,
+		); err != nil {
+			return
+		}
+
+		logDebugEntry.Debug("Applying the notice rules")
+		if event.NoticeSent, err = cli.SendNoticeWithRules(data, &types.NoticeEvent{
+			Event:            event.Event,
+			Action:           event.Action,
+			Added:            added,
+			Removed:          removed,
+			PreviousState:    from,
+			HasPreviousState: known,
+		}); err != nil {
+			return
 		}
+	}
+
+	// Report the conflicts once the notice has been sent, so they aren't
+	// reported again if sending it fails and processing the event is retried.
+	if err = reportConflicts(data, conflicts, cli, db, logDebugEntry); err != nil {
+		return
+	}
 
-		// Implementation of Informo's SCSP: extract the submission's type or
-		// SCSP state from the label name.
-		// "xxx" can either be "type", which is the type of the changes
-		// submitted (typo, behaviour), or "scsp", which is the SCS's SCSP
-		// state.
-		switch split[0] {
-		case "type":
-			// If more than one type is defined, return and do nothing.
-			if len(data.Type) > 0 {
-				logDebugEntry.WithField("type", split[1]).Debug("Got another type, aborting")
-				return
-			}
-			data.Type = split[1]
+	event.Type, event.State = data.Type, data.State
+
+	// Save the new proposal's state and record the event.
+	// We could have done that earlier, but should the notice sending fail we'd
+	// want the next event to be processed with the previous state.
+	if err = db.UpdateProposalState(*event, labels); err != nil {
+		return
+	}
+
+	// Schedule or cancel the proposal's reminder according to its new state.
+	return rem.Schedule(data, *event)
+}
+
+// parseLabels extracts the submission's type and SCSP state from the given
+// label names using the given label grammar, and fills the given SCS data with
+// them.
+// Returns the conflicts between label names if there's too much information
+// (i.e. more than one matching label name) for the submission's type or SCSP
+// state, in which case the conflicting type or SCSP state is left empty in the
+// SCS data.
+func parseLabels(
+	data *types.SCSData, labels []string, grammar *config.LabelGrammar,
+	logDebugEntry *logrus.Entry,
+) (conflicts []types.LabelConflict) {
+	conflicts = []types.LabelConflict{}
+
+	// Label names matching the submission's type and SCSP state.
+	typeLabels := make([]string, 0)
+	stateLabels := make([]string, 0)
+
+	var l string
+	for _, l = range labels {
+		// Extract the submission's type or SCSP state from the label name. In
+		// the Informo SCSP, which the default grammar implements, labels follow
+		// the form "xxx:yyy", such as "xxx" is either "type", which is the
+		// type of the changes submitted (typo, behaviour), or "scsp", which is
+		// the SCS's SCSP state, and "yyy" is the type or state.
+		kind, value := grammar.Match(l)
+		switch kind {
+		case config.LabelKindType:
+			data.Type = value
+			typeLabels = append(typeLabels, l)
 			logDebugEntry.WithField("type", data.Type).Debug("Got the submission type")
-		case "scsp":
-			// If more than one SCSP state is defined, return and do noting.
-			if len(data.State) > 0 {
-				logDebugEntry.WithField("state", split[1]).Debug("Got another state, aborting")
-				return
-			}
-			data.State = split[1]
+		case config.LabelKindState:
+			data.State = value
+			stateLabels = append(stateLabels, l)
 			logDebugEntry.WithField("state", data.State).Debug("Got the SCSP state")
 		default:
-			// If the first element in the split doesn't follow the Informo
-			// SCSP, it means we should process this label name with the generic
-			// workflow if we can (i.e. if a type or state can't be extracted
-			// from other label names).
-			logDebugEntry.WithField("name", l).Debug("Label name could be split but doesn't implement the SCSP")
-			unsplittableLabels = append(unsplittableLabels, l)
-			break
+			logDebugEntry.WithField("name", l).Debug("Label name doesn't implement the SCSP")
 		}
 	}
 
-	// Redefine the log entry's fields to append the type and state now that we
-	// have both of them in their definite state (i.e. their finite value or we
-	// know one or more haven't been provided).
-	logDebugEntry = logDebugEntry.WithFields(logrus.Fields{
-		"type":  data.Type,
-		"state": data.State,
-	})
+	// If more than one type or SCSP state is defined, report it.
+	if len(typeLabels) > 1 {
+		logDebugEntry.WithField("labels", typeLabels).Debug("Got more than one type")
+		data.Type = ""
+		conflicts = append(conflicts, types.LabelConflict{
+			Kind:   config.LabelKindType,
+			Labels: typeLabels,
+		})
+	}
 
-	if len(data.Type) != 0 && len(data.State) != 0 {
-		// At this point we're pretty sure the submission implements Informo's SCSP,
-		// so we use the dedicated workflow.
-		logDebugEntry.Debug("Calling the Informo SCSP dedicated workflow")
-		return cli.SendNoticeWithTypeAndState(data)
+	if len(stateLabels) > 1 {
+		logDebugEntry.WithField("labels", stateLabels).Debug("Got more than one state")
+		data.State = ""
+		conflicts = append(conflicts, types.LabelConflict{
+			Kind:   config.LabelKindState,
+			Labels: stateLabels,
+		})
 	}
 
-	// If the submission's type or SCSP state couldn't be determined from the
-	// label names, it can either mean that the submission doesn't implement the
-	// Informo SCSP, or a list of labels implementing it will come in a future
-	// payload. To process the former case and ignore the latter, we use a
-	// generic workflow that only processes labels that couldn't be split
-	// accordingly with the Informo SCSP and for which a message string has been
-	// defined.
-	// For this workflow we will need to process the proposal's state and filter
-	// out labels that were already in it before the event happened.
+	return
+}
 
-	// Retrieve the proposal's state.
-	state, err := getState(db, number)
+// lifecycleEvent returns the name of the lifecycle event described by the given
+// pull request event's payload, which is the event's action, except for closed
+// pull requests that are "merged" if they have been merged, "closed" otherwise.
+// Returns false if the event's action isn't related to the PR's lifecycle.
+func lifecycleEvent(pl github.PullRequestPayload) (string, bool) {
+	switch pl.Action {
+	case "opened", "reopened", "ready_for_review", "converted_to_draft":
+		return pl.Action, true
+	case "closed":
+		if pl.PullRequest.Merged {
+			return "merged", true
+		}
+		return "closed", true
+	}
+
+	return "", false
+}
+
+// handleLifecycleEvent uses the given data referring to a pull request or an
+// issue to generate and send a notice for the lifecycle event described by the
+// given event's action, using the configured notice rules, which by default use
+// the message string defined for a pull request's lifecycle event in the
+// "pull_request" section of the strings file, and don't send any notice for an
+// issue's. The submission's labels are used to determine its type and SCSP
+// state if possible. It then saves the submission's new state, records the
+// given event in the submission's history, and schedules or cancels its
+// reminder accordingly.
+// Doesn't send any notice if no notice rule selects a message string for this
+// lifecycle event.
+// Returns with an error if the notice couldn't be sent, if the submission's
+// state couldn't be retrieved or saved, or if its reminder couldn't be
+// updated.
+func handleLifecycleEvent(
+	event *types.ProposalEvent, data *types.SCSData, cfg *config.Config,
+	cli *matrix.Cli, db *database.Database, rem *reminder.Scheduler,
+) (err error) {
+	repository, number, labels := data.Repository, data.Number, data.Labels
+
+	logDebugEntry := logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+		"title":      data.Title,
+		"url":        data.URL,
+		"labels":     labels,
+		"event":      event.Action,
+	})
+
+	logDebugEntry.Debug("Handling lifecycle event")
+
+	// If a previous attempt at processing this event got as far as recording
+	// it, only its reminder is left to update.
+	if done, err := resumeRecordedEvent(event, data, db, rem); err != nil || done {
+		return err
+	}
+
+	// Retrieve the proposal's state, i.e. its labels before the event
+	// happened.
+	state, err := getState(db, repository, number)
 	if err != nil {
 		return
 	}
+	event.LabelsAdded, event.LabelsRemoved = diffLabels(state, labels)
 
-	// Filter the unsplittable labels so we're only left with the ones we didn't
-	// previously have.
-	filteredLabels := make([]string, 0)
-	for _, l := range unsplittableLabels {
-		if _, exists := state[l]; !exists {
-			filteredLabels = append(filteredLabels, l)
-		}
+	// Try to determine the submission's type and SCSP state, which are only
+	// used to fill the notice and match the notice rules and the rooms'
+	// filters, so it doesn't matter if they can't be determined.
+	parseLabels(data, labels, labelGrammar(cfg, repository), logDebugEntry)
+
+	if event.NoticeSent, err = cli.SendNoticeWithRules(data, &types.NoticeEvent{
+		Event:  event.Event,
+		Action: event.Action,
+	}); err != nil {
+		return
 	}
 
-	// Use the generic workflow with the filtered set of labels
-	logDebugEntry.Debug("Calling the generic workflow")
-	if err = cli.SendNoticeWithUnsplitLabels(data, filteredLabels); err != nil {
+	event.Type, event.State = data.Type, data.State
+
+	if err = db.UpdateProposalState(*event, labels); err != nil {
 		return
 	}
 
-	// Save the new proposal's state.
-	// We could have done that earlier, but should the notice sending fail we'd
-	// want the next event to be processed with the previous state.
-	return db.UpdateProposalState(number, labels)
+	// Schedule or cancel the proposal's reminder according to its new state.
+	return rem.Schedule(data, *event)
 }
 
-// getState retrieves the state of a given proposal from the database and
-// converts it into a map.
+// resumeRecordedEvent checks whether the given event has already been recorded
+// by a previous attempt at processing the webhook delivery it comes from, in
+// which case its notice has already been sent and the submission's state
+// saved. If so, it fills the given SCS data with the type and SCSP state
+// recorded for the event, and schedules or cancels the submission's reminder
+// accordingly, as it's the only step that can be left.
+// Returns false if the event hasn't been recorded, or if the delivery it comes
+// from isn't known.
+// Returns with an error if the recorded event couldn't be retrieved, or if the
+// submission's reminder couldn't be updated.
+func resumeRecordedEvent(
+	event *types.ProposalEvent, data *types.SCSData, db *database.Database,
+	rem *reminder.Scheduler,
+) (done bool, err error) {
+	if len(event.DeliveryID) == 0 {
+		return
+	}
+
+	recorded, done, err := db.GetDeliveryEvent(
+		event.Repository, event.Number, event.DeliveryID,
+	)
+	if err != nil || !done {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"repository":  event.Repository,
+		"number":      event.Number,
+		"delivery_id": event.DeliveryID,
+	}).Debug("Event already recorded, resuming its processing")
+
+	data.Type, data.State = recorded.Type, recorded.State
+	err = rem.Schedule(data, recorded)
+	return
+}
+
+// diffLabels returns the labels in the given list that aren't in the given
+// state, and the labels in the given state that aren't in the given list. The
+// removed labels are sorted alphabetically, as the state doesn't keep track of
+// the labels' order.
+func diffLabels(state map[string]bool, labels []string) (added []string, removed []string) {
+	added = make([]string, 0)
+	current := make(map[string]bool)
+	for _, l := range labels {
+		current[l] = true
+		if !state[l] {
+			added = append(added, l)
+		}
+	}
+
+	removed = make([]string, 0)
+	for l := range state {
+		if !current[l] {
+			removed = append(removed, l)
+		}
+	}
+	sort.Strings(removed)
+
+	return
+}
+
+// changedLabel returns the name of the label added or removed by an event with
+// the given action, given the name of the label the event is about. Both are
+// empty if the event isn't about a label being added or removed.
+func changedLabel(action string, label string) (added string, removed string) {
+	switch action {
+	case "labeled":
+		return label, ""
+	case "unlabeled":
+		return "", label
+	}
+
+	return "", ""
+}
+
+// previousState determines the SCSP state a submission was in before the
+// update, which is the latest SCSP state recorded in its history. This way, a
+// SCSP label being replaced with another one in two events (i.e. the former
+// being removed, then the latter being added) is seen as a transition between
+// the two states. If no SCSP state has been recorded in the submission's
+// history (e.g. because it predates it), the previous SCSP state is determined
+// from its given state before the update, i.e. its labels before the update.
+// Returns an empty string if the submission didn't have a SCSP state.
+// Returns false if the previous state has to be determined from the previous
+// labels and they define more than one type or SCSP state, in which case the
+// previous SCSP state can't be determined.
+// Returns with an error if the submission's history couldn't be retrieved.
+func previousState(
+	db *database.Database, repository string, number int64,
+	state map[string]bool, grammar *config.LabelGrammar,
+	logDebugEntry *logrus.Entry,
+) (string, bool, error) {
+	recorded, ok, err := db.GetLatestProposalState(repository, number)
+	if err != nil || ok {
+		return recorded, ok, err
+	}
+
+	labels := make([]string, 0, len(state))
+	for l := range state {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	previous := new(types.SCSData)
+	conflicts := parseLabels(
+		previous, labels, grammar, logDebugEntry.WithField("previous", true),
+	)
+	return previous.State, len(conflicts) == 0, nil
+}
+
+// labelGrammar returns the label grammar configured for the given repository,
+// or the default one if the repository isn't configured.
+func labelGrammar(cfg *config.Config, repository string) *config.LabelGrammar {
+	if repo, ok := cfg.Repository(repository); ok {
+		return repo.Labels
+	}
+
+	return &cfg.Notices.Labels
+}
+
+// getState retrieves the state of a given proposal of a given repository from
+// the database and converts it into a map.
 // Returns an error if the database driver returns one.
-func getState(db *database.Database, number int64) (map[string]bool, error) {
+func getState(
+	db *database.Database, repository string, number int64,
+) (map[string]bool, error) {
 	// Retrieve the proposal's state.
-	state, err := db.GetProposalState(number)
+	state, err := db.GetProposalState(repository, number)
 	if err != nil {
 		return nil, err
 	}
@@ -268,10 +680,13 @@ func getState(db *database.Database, number int64) (map[string]bool, error) {
 	return stateMap, nil
 }
 
-// unlockAndReturnErr unlocks the mutex for a given proposal and returns with a
-// given error.
-func unlockAndReturnErr(number int64, err error) error {
-	mutex.Unlock(number)
-	logrus.WithField("number", number).Debug("Unlocked mutex")
+// unlockAndReturnErr unlocks the mutex for a given proposal of a given
+// repository and returns with a given error.
+func unlockAndReturnErr(repository string, number int64, err error) error {
+	mutex.Unlock(repository, number)
+	logrus.WithFields(logrus.Fields{
+		"repository": repository,
+		"number":     number,
+	}).Debug("Unlocked mutex")
 	return err
 }
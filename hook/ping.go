@@ -0,0 +1,34 @@
+package hook
+
+import (
+	"github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/webhooks.v5/github"
+)
+
+// HandlePingPayload processes the payload of a ping event, which GitHub sends
+// when the webhook is created. It logs the hook's ID and the events it's been
+// configured to send, and warns about the handled events it won't send.
+func HandlePingPayload(pl github.PingPayload) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"hook_id": pl.HookID,
+		"events":  pl.Hook.Events,
+	})
+
+	logEntry.Info("Got ping from GitHub")
+
+	// Build a set of the events the hook has been configured to send. The "*"
+	// wildcard means the hook sends every event.
+	configured := make(map[string]bool)
+	for _, e := range pl.Hook.Events {
+		if e == "*" {
+			return
+		}
+		configured[e] = true
+	}
+
+	for _, e := range HandledEvents {
+		if !configured[string(e)] {
+			logEntry.WithField("event", e).Warn("The webhook isn't configured to send an event the bot handles")
+		}
+	}
+}
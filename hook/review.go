@@ -0,0 +1,102 @@
+package hook
+
+import (
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/mutex"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/webhooks.v5/github"
+)
+
+// HandlePullRequestReviewPayload processes the payload of a pull request review
+// event received by the GitHub webhook. If the event's action is "submitted",
+// it generates and sends a notice for the review using the configured notice
+// rules, which by default use the message string defined for the review's
+// state (e.g. "approved" or "changes_requested") in the "review" section of
+// the strings file. The given draft status is the PR's, which the webhooks
+// library doesn't decode. As review payloads don't include the PR's labels,
+// the labels saved in the proposal's state are used to determine the
+// submission's type and SCSP state if possible. The review is then recorded in
+// the proposal's history.
+// Returns and do nothing if the event's action isn't "submitted". Doesn't send
+// any notice if no notice rule selects a message string for the review.
+// Returns with an error if the proposal's state couldn't be retrieved, if the
+// notice couldn't be sent or if the review couldn't be recorded.
+func HandlePullRequestReviewPayload(
+	pl github.PullRequestReviewPayload, draft bool, deliveryID string,
+	cfg *config.Config, cli *matrix.Cli, db *database.Database,
+) (err error) {
+	repo := pl.Repository.FullName
+
+	logDebugEntry := logrus.WithFields(logrus.Fields{
+		"action":     pl.Action,
+		"repository": repo,
+		"number":     pl.PullRequest.Number,
+		"state":      pl.Review.State,
+		"reviewer":   pl.Review.User.Login,
+	})
+
+	logDebugEntry.Debug("Got PR review event payload")
+
+	// Only process newly submitted reviews.
+	if pl.Action != "submitted" {
+		logDebugEntry.Debug("Ignoring PR review")
+		return nil
+	}
+
+	logDebugEntry.Debug("Processing PR review")
+
+	pr := pl.PullRequest
+
+	// Lock the mutex for this proposal in order to make sure it doesn't get
+	// updated by another event before we're done with this one.
+	mutex.Lock(repo, pr.Number)
+
+	// Retrieve the labels' names from the proposal's state.
+	labels, err := db.GetProposalState(repo, pr.Number)
+	if err != nil {
+		return unlockAndReturnErr(repo, pr.Number, err)
+	}
+
+	data := &types.SCSData{
+		Repository: repo,
+		Number:     pr.Number,
+		Title:      pr.Title,
+		URL:        pr.HTMLURL,
+		Labels:     labels,
+		Actor:      pl.Review.User.Login,
+		Author:     pr.User.Login,
+		Draft:      draft,
+	}
+
+	// Try to determine the submission's type and SCSP state, which are only
+	// used to fill the notice and match the notice rules and the rooms'
+	// filters, so it doesn't matter if they can't be determined.
+	parseLabels(data, labels, labelGrammar(cfg, repo), logDebugEntry)
+
+	event := types.ProposalEvent{
+		Repository: repo,
+		Number:     pr.Number,
+		OccurredAt: pl.Review.SubmittedAt,
+		Actor:      pl.Sender.Login,
+		Event:      string(github.PullRequestReviewEvent),
+		Action:     pl.Review.State,
+		Type:       data.Type,
+		State:      data.State,
+		DeliveryID: deliveryID,
+	}
+
+	if event.NoticeSent, err = cli.SendNoticeWithRules(data, &types.NoticeEvent{
+		Event:  event.Event,
+		Action: event.Action,
+	}); err != nil {
+		return unlockAndReturnErr(repo, pr.Number, err)
+	}
+
+	// Record the review in the proposal's history.
+	err = db.RecordProposalEvent(event)
+	return unlockAndReturnErr(repo, pr.Number, err)
+}
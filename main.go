@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Informo/specs-bot/config"
 	"github.com/Informo/specs-bot/database"
 	"github.com/Informo/specs-bot/hook"
 	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/queue"
+	"github.com/Informo/specs-bot/reminder"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/go-playground/webhooks.v5/github"
@@ -32,14 +40,23 @@ func main() {
 	}
 	logrus.Debug("Configuration loaded")
 
-	// Instantiate a Matrix client.
-	cli, err := matrix.NewCli(
-		cfg.Matrix.HSURL, cfg.Matrix.MXID, cfg.Matrix.AccessToken, cfg,
-	)
-	if err != nil {
-		logrus.Panic(err)
+	// Run the migrate subcommand if it's been provided instead of starting the
+	// bot.
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(cfg, flag.Arg(1)); err != nil {
+			logrus.Panic(err)
+		}
+		return
+	}
+
+	// Run the report subcommand if it's been provided instead of starting the
+	// bot.
+	if flag.Arg(0) == "report" {
+		if err = runReport(cfg, flag.Arg(1)); err != nil {
+			logrus.Panic(err)
+		}
+		return
 	}
-	logrus.Debug("Matrix client instantiated")
 
 	// Instantiate the database and prepare statements.
 	db, err := database.NewDatabase(cfg)
@@ -48,58 +65,262 @@ func main() {
 	}
 	logrus.Debug("Database instantiated")
 
-	// Instantiate a GitHub webhook.
-	h, err := github.New(github.Options.Secret(cfg.Webhook.Secret))
+	// Instantiate a Matrix client.
+	cli, err := matrix.NewCli(
+		cfg.Matrix.HSURL, cfg.Matrix.MXID, cfg.Matrix.AccessToken, cfg, db,
+	)
 	if err != nil {
 		logrus.Panic(err)
 	}
-	logrus.Debug("GitHub webhook instantiated")
+	logrus.Debug("Matrix client instantiated")
+
+	// Instantiate a GitHub webhook for each repository, as each of them can
+	// use its own secret.
+	hooks := make(map[*config.RepositoryConfig]*github.Webhook)
+	for i := range cfg.Repositories {
+		repo := &(cfg.Repositories[i])
+		if hooks[repo], err = github.New(github.Options.Secret(repo.Secret)); err != nil {
+			logrus.Panic(err)
+		}
+	}
+	logrus.Debug("GitHub webhooks instantiated")
+
+	// Instantiate the scheduler of the reminders, and start sending the ones
+	// which are due.
+	rem := reminder.NewScheduler(cfg, db, cli)
+	rem.Start()
+	logrus.Debug("Reminder scheduler started")
+
+	// Instantiate the queue the webhook payloads are stored into before being
+	// processed, and start processing them.
+	q := queue.NewQueue(cfg, db, func(
+		event string, deliveryID string, payload []byte,
+	) error {
+		return hook.HandlePayload(event, deliveryID, payload, cfg, cli, db, rem)
+	})
+	if err = q.Start(); err != nil {
+		logrus.Panic(err)
+	}
+	logrus.Debug("Queue started")
 
 	// Define the HTTP handler for the webhook.
 	http.HandleFunc(cfg.Webhook.Path, func(w http.ResponseWriter, r *http.Request) {
-		var payload interface{}
-		// Retrieve the payload if the event is a pull request event.
-		payload, err = h.Parse(r, github.PullRequestEvent, github.IssuesEvent)
+		// If the event isn't one we handle, apply the configured policy and
+		// tell the sender which events we expect, regardless of the repository
+		// the payload is about.
+		event := r.Header.Get("X-GitHub-Event")
+		if github.Event(event) != github.PingEvent && !isHandledEvent(event) {
+			handleUnknownEvent(w, r, cfg.Webhook.UnknownEvents)
+			return
+		}
+
+		// Find out which repository the payload is about, so it's verified
+		// using this repository's secret.
+		repo, body, err := payloadRepository(r)
 		if err != nil {
-			// If the event isn't a pull request event, notify the sender that
-			// the request isn't within what's expected and return.
-			if err == github.ErrEventNotFound {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		repoCfg, ok := cfg.Repository(repo)
+
+		// Acknowledge pings right away. Pings for an organisation's hook don't
+		// mention any repository, so they can't be verified and are only
+		// logged.
+		if github.Event(event) == github.PingEvent {
+			if err = handlePing(hooks[repoCfg], r, body); err != nil {
+				logrus.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
 			}
+			return
+		}
 
+		if !ok {
+			logrus.WithField("repository", repo).Warn("Rejecting payload for unknown repository")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unsupported repository %q\n", repo)
+			return
+		}
+
+		// Verify the payload using the repository's secret.
+		if _, err = hooks[repoCfg].Parse(r, hook.HandledEvents...); err != nil {
 			logrus.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// Handle both issues and pull requests payloads.
-		switch payload.(type) {
-		case github.PullRequestPayload:
-			err = hook.HandlePullRequestPayload(
-				payload.(github.PullRequestPayload), cli, db,
-			)
-			break
-		case github.IssuesPayload:
-			err = hook.HandleIssuesPayload(
-				payload.(github.IssuesPayload), cli, db,
-			)
-			break
+		// Skip the deliveries that have already been processed successfully,
+		// which can happen if GitHub or an administrator sends one again.
+		delivery := r.Header.Get("X-GitHub-Delivery")
+		logEntry := logrus.WithFields(logrus.Fields{
+			"delivery_id": delivery,
+			"event":       event,
+		})
+		if len(delivery) > 0 {
+			var processed bool
+			if processed, err = db.IsDeliveryProcessed(delivery); err != nil {
+				logEntry.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if processed {
+				logEntry.Info("Delivery already processed, skipping")
+				return
+			}
 		}
 
-		// If any of the handler or workflow returned with an error, log it and
-		// tell the user something went wrong.
-		if err != nil {
-			logrus.Error(err)
+		// Store the payload in the queue so it gets processed by the workers,
+		// and tell the sender it has been accepted without waiting for the
+		// processing to happen. The raw payload is stored rather than the
+		// parsed one, so the fields the webhooks library doesn't decode (e.g.
+		// a pull request's draft status) are still available.
+		if err = q.Enqueue(delivery, event, json.RawMessage(body)); err != nil {
+			logEntry.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+
+		logEntry.Debug("Payload queued")
+		w.WriteHeader(http.StatusAccepted)
 	})
 	logrus.WithField("path", cfg.Webhook.Path).Debug("Defined HTTP handler")
 
+	// Define the HTTP handler for the reports if enabled.
+	if len(cfg.Reports.Path) > 0 {
+		http.HandleFunc(cfg.Reports.Path, reportHandler(cfg, db))
+		logrus.WithField("path", cfg.Reports.Path).Debug("Defined reports HTTP handler")
+	}
+
+	// Regularly prune the deliveries, finished jobs and relayed comments older
+	// than the retention window.
+	go prune(db, cfg.Webhook.DeliveryRetention)
+
 	// Start the HTTP server.
 	logrus.WithField("listen_addr", cfg.Webhook.ListenAddr).Info("Starting web server")
 	if err = http.ListenAndServe(cfg.Webhook.ListenAddr, nil); err != nil {
 		logrus.Panic(err)
 	}
 }
+
+// isHandledEvent checks whether the given GitHub event is one of the events
+// the webhook processes.
+func isHandledEvent(event string) bool {
+	for _, e := range hook.HandledEvents {
+		if string(e) == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlePing processes the ping payload in the given request body. If a
+// webhook is given (i.e. the ping is for a configured repository), the payload
+// is verified and parsed with it, otherwise it is decoded as is.
+// Returns an error if the payload couldn't be verified or decoded.
+func handlePing(h *github.Webhook, r *http.Request, body []byte) error {
+	var ping github.PingPayload
+	if h != nil {
+		payload, err := h.Parse(r, github.PingEvent)
+		if err != nil {
+			return err
+		}
+		ping = payload.(github.PingPayload)
+	} else if err := json.Unmarshal(body, &ping); err != nil {
+		return err
+	}
+
+	hook.HandlePingPayload(ping)
+	return nil
+}
+
+// handleUnknownEvent responds to a request for an event the webhook doesn't
+// handle according to the given policy, with a body listing the events the
+// webhook expects.
+func handleUnknownEvent(w http.ResponseWriter, r *http.Request, policy string) {
+	expected := make([]string, 0, len(hook.HandledEvents))
+	for _, e := range hook.HandledEvents {
+		expected = append(expected, string(e))
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	logEntry := logrus.WithFields(logrus.Fields{
+		"event":    event,
+		"policy":   policy,
+		"expected": expected,
+	})
+
+	if policy == config.UnknownEventsAccept {
+		logEntry.Debug("Ignoring unknown event")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(
+			w, "Ignoring event %q, expected events are: %s\n", event,
+			strings.Join(expected, ", "),
+		)
+		return
+	}
+
+	logEntry.Warn("Rejecting unknown event")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(
+		w, "Unsupported event %q, expected events are: %s\n", event,
+		strings.Join(expected, ", "),
+	)
+}
+
+// payloadRepository reads the body of the given request to retrieve the full
+// name of the repository the payload is about, then restores the body so it can
+// be read again. It also returns the body, so the raw payload can be queued.
+// Returns an empty string if the body doesn't mention a repository or isn't
+// valid JSON.
+// Returns an error if the body couldn't be read.
+func payloadRepository(r *http.Request) (string, []byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var pl struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err = json.Unmarshal(body, &pl); err != nil {
+		return "", body, nil
+	}
+
+	return pl.Repository.FullName, body, nil
+}
+
+// prune deletes the webhook deliveries received, the finished jobs queued and
+// the records of the comments relayed before the given retention window from
+// the database, then does it again every hour. It is meant to be run in its own goroutine.
+func prune(db *database.Database, retention time.Duration) {
+	for {
+		before := time.Now().Add(-retention)
+
+		if pruned, err := db.PruneDeliveries(before); err != nil {
+			logrus.Error(err)
+		} else {
+			logrus.WithField("pruned", pruned).Debug("Pruned old deliveries")
+		}
+
+		if pruned, err := db.PruneJobs(before); err != nil {
+			logrus.Error(err)
+		} else {
+			logrus.WithField("pruned", pruned).Debug("Pruned old jobs")
+		}
+
+		if pruned, err := db.PruneRelayedComments(before); err != nil {
+			logrus.Error(err)
+		} else {
+			logrus.WithField("pruned", pruned).Debug("Pruned old relayed comments")
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
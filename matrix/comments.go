@@ -0,0 +1,174 @@
+package matrix
+
+import (
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RelayComment generates a message from the comment data and the configured
+// comments template, and sends it as a notice to the rooms configured for
+// comments. If threads are enabled for comments, the notice is sent in the
+// proposal's thread in each room if there's one. If the comment comes from a
+// known webhook delivery, the rooms it has been relayed to are recorded, so it
+// isn't relayed to them again if processing the delivery is retried.
+// Returns and do nothing if no room is configured for comments, if the
+// proposal isn't tracked (i.e. no notice has been sent for it yet), or if the
+// comment doesn't match the configured filters.
+// Returns with an error if there was an issue talking to the database or
+// generating the message from the configured template, or with a SendError
+// listing the rooms the comment couldn't be sent to if there are any.
+func (c *Cli) RelayComment(data *types.CommentData) (err error) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"repository":  data.Repository,
+		"number":      data.Number,
+		"author":      data.Author,
+		"association": data.AuthorAssociation,
+		"comment_url": data.CommentURL,
+	})
+
+	cfg := c.cfg.Comments
+	if len(cfg.Rooms) == 0 {
+		logEntry.Debug("No room configured for comments")
+		return
+	}
+
+	// Only relay comments on proposals we've already sent notices for.
+	_, tracked, err := c.db.GetLastNotice(data.Repository, data.Number)
+	if err != nil {
+		return
+	}
+
+	if !tracked {
+		logEntry.Debug("Proposal isn't tracked, not relaying comment")
+		return
+	}
+
+	if !commentAccepted(cfg.AuthorAssociations, cfg.MinLength, cfg.OptInLabel, data) {
+		logEntry.Debug("Comment filtered out")
+		return
+	}
+
+	// Load the template defined in the configuration file and generate the
+	// message from it.
+	tmpl, err := template.New("comment").Parse(cfg.Pattern)
+	if err != nil {
+		logEntry.Debug("Could not load template")
+		return
+	}
+
+	var b strings.Builder
+	if err = tmpl.Execute(&b, data); err != nil {
+		logEntry.Debug("Could not build comment message from template")
+		return
+	}
+
+	var sendErr *SendError
+	for _, room := range cfg.Rooms {
+		// If there was an error sending the comment to a specific room,
+		// display the error without breaking from the loop in order to send it
+		// to as much rooms possible.
+		if err = c.sendCommentToRoom(room, data, b.String()); err != nil {
+			logEntry.WithField("room_id", room).Error(err)
+			sendErr = sendErr.add(room, err)
+		}
+	}
+
+	logEntry.Debug("Comment relayed")
+
+	return sendErr.errOrNil()
+}
+
+// sendCommentToRoom sends the given comment message as a notice to a single
+// Matrix room, in the proposal's thread if threads are enabled for comments and
+// there's a thread for this proposal in this room, and records it if the
+// comment comes from a known webhook delivery.
+// Returns and do nothing if the comment has already been relayed to the room.
+// Returns with an error if there was an issue talking to the database or if the
+// notice couldn't be sent.
+func (c *Cli) sendCommentToRoom(
+	roomID string, data *types.CommentData, body string,
+) (err error) {
+	if len(data.DeliveryID) > 0 {
+		var relayed bool
+		if relayed, err = c.db.IsCommentRelayed(data.DeliveryID, roomID); err != nil {
+			return
+		}
+
+		if relayed {
+			logrus.WithFields(logrus.Fields{
+				"delivery_id": data.DeliveryID,
+				"room_id":     roomID,
+			}).Debug("Comment already relayed to room")
+			return
+		}
+	}
+
+	content := newNoticeContent(body, "")
+
+	if c.cfg.Comments.Threads {
+		var root, latest string
+		if root, _, err = c.db.GetThreadRoot(
+			data.Repository, data.Number, roomID,
+		); err != nil {
+			return
+		}
+		if latest, _, err = c.db.GetLatestOriginalNotice(
+			data.Repository, data.Number, roomID,
+		); err != nil {
+			return
+		}
+
+		if len(root) > 0 {
+			content = newThreadContent(body, "", root, latest)
+		}
+	}
+
+	resp, err := c.sendMessageEvent(roomID, content)
+	if err != nil {
+		return
+	}
+
+	if len(data.DeliveryID) > 0 {
+		err = c.db.SaveRelayedComment(data.DeliveryID, roomID, resp.EventID)
+	}
+
+	return
+}
+
+// commentAccepted checks whether the given comment matches the configured
+// filters, i.e. whether its author's association with the repository is one
+// of the given ones (if any), its body is at least as long as the given
+// minimum length, and the proposal carries the given opt-in label (if any).
+func commentAccepted(
+	associations []string, minLength int, optInLabel string,
+	data *types.CommentData,
+) bool {
+	if len(associations) > 0 {
+		var found bool
+		for _, a := range associations {
+			if strings.EqualFold(a, data.AuthorAssociation) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if utf8.RuneCountInString(strings.TrimSpace(data.Body)) < minLength {
+		return false
+	}
+
+	if len(optInLabel) > 0 && !types.Contains(data.Labels, optInLabel) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// conflictReport is the data available to the template of the reports of
+// conflicts between labels. Labels is the comma-separated list of the
+// conflicting labels' names.
+type conflictReport struct {
+	Repository string
+	Number     int64
+	Title      string
+	URL        string
+	Kind       string
+	Labels     string
+	Resolution string
+	Actor      string
+}
+
+// ReportLabelConflict reports the given conflict between the labels of the SCS
+// described by the given data to the admin room.
+// Returns and do nothing if no admin room is configured.
+// Returns with an error if the report couldn't be generated from the
+// configured template or sent.
+func (c *Cli) ReportLabelConflict(
+	data *types.SCSData, conflict types.LabelConflict,
+) (err error) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"repository": data.Repository,
+		"number":     data.Number,
+		"kind":       conflict.Kind,
+		"labels":     conflict.Labels,
+		"resolution": conflict.Resolution,
+	})
+
+	cfg := c.cfg.Conflicts
+	if len(cfg.AdminRoom) == 0 {
+		logEntry.Debug("No admin room configured, not reporting conflict")
+		return
+	}
+
+	tmpl, err := template.New("conflict").Parse(cfg.Pattern)
+	if err != nil {
+		logEntry.Debug("Could not load template")
+		return
+	}
+
+	var b strings.Builder
+	if err = tmpl.Execute(&b, &conflictReport{
+		Repository: data.Repository,
+		Number:     data.Number,
+		Title:      data.Title,
+		URL:        data.URL,
+		Kind:       conflict.Kind,
+		Labels:     strings.Join(conflict.Labels, ", "),
+		Resolution: conflict.Resolution,
+		Actor:      conflict.Actor,
+	}); err != nil {
+		logEntry.Debug("Could not build conflict report from template")
+		return
+	}
+
+	if _, err = c.sendMessageEvent(
+		cfg.AdminRoom, newNoticeContent(b.String(), ""),
+	); err != nil {
+		return
+	}
+
+	logEntry.Debug("Conflict reported")
+
+	return
+}
@@ -0,0 +1,87 @@
+package matrix
+
+// htmlFormat is the format used for formatted bodies.
+const htmlFormat = "org.matrix.custom.html"
+
+// noticeContent is the content of a m.notice message event.
+type noticeContent struct {
+	MsgType       string         `json:"msgtype"`
+	Body          string         `json:"body"`
+	Format        string         `json:"format,omitempty"`
+	FormattedBody string         `json:"formatted_body,omitempty"`
+	NewContent    *noticeContent `json:"m.new_content,omitempty"`
+	RelatesTo     *relatesTo     `json:"m.relates_to,omitempty"`
+}
+
+// relatesTo describes the relation between an event and another one.
+type relatesTo struct {
+	RelType       string     `json:"rel_type,omitempty"`
+	EventID       string     `json:"event_id,omitempty"`
+	IsFallingBack bool       `json:"is_falling_back,omitempty"`
+	InReplyTo     *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+// inReplyTo identifies the event another event is a reply to.
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// newNoticeContent returns the content of a m.notice message event with the
+// given body. If the given formatted body isn't empty, it is added to the
+// content as HTML.
+func newNoticeContent(body string, formattedBody string) *noticeContent {
+	content := &noticeContent{
+		MsgType: "m.notice",
+		Body:    body,
+	}
+
+	if len(formattedBody) > 0 {
+		content.Format = htmlFormat
+		content.FormattedBody = formattedBody
+	}
+
+	return content
+}
+
+// newEditContent returns the content of a m.notice message event replacing the
+// event with the given ID with the given body and formatted body. As
+// recommended by the Matrix specification, the fallback bodies are prefixed
+// with an asterisk for clients that don't support edits.
+func newEditContent(
+	body string, formattedBody string, eventID string,
+) *noticeContent {
+	var fallbackFormattedBody string
+	if len(formattedBody) > 0 {
+		fallbackFormattedBody = "* " + formattedBody
+	}
+
+	content := newNoticeContent("* "+body, fallbackFormattedBody)
+
+	content.NewContent = newNoticeContent(body, formattedBody)
+	content.RelatesTo = &relatesTo{
+		RelType: "m.replace",
+		EventID: eventID,
+	}
+
+	return content
+}
+
+// newThreadContent returns the content of a m.notice message event with the
+// given body and formatted body, sent in the thread which root is the event
+// with the given ID. The event also replies to the latest event in the thread
+// as a fallback for clients that don't support threads.
+func newThreadContent(
+	body string, formattedBody string, rootID string, latestID string,
+) *noticeContent {
+	content := newNoticeContent(body, formattedBody)
+	content.RelatesTo = &relatesTo{
+		RelType:       "m.thread",
+		EventID:       rootID,
+		IsFallingBack: true,
+		InReplyTo: &inReplyTo{
+			EventID: latestID,
+		},
+	}
+
+	return content
+}
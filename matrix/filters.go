@@ -0,0 +1,51 @@
+package matrix
+
+import (
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/types"
+)
+
+// roomAccepts checks whether a notice for the given SCS data should be sent to
+// the given room. A room with no filter accepts every notice, otherwise the
+// notice must match at least one of the room's filters.
+func roomAccepts(room config.RoomConfig, data *types.SCSData) bool {
+	if len(room.Filters) == 0 {
+		return true
+	}
+
+	for _, f := range room.Filters {
+		if filterMatches(f, data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterMatches checks whether the given SCS data matches every non-empty list
+// in the given filter.
+func filterMatches(f config.NoticeFilter, data *types.SCSData) bool {
+	if len(f.Types) > 0 && !types.Contains(f.Types, data.Type) {
+		return false
+	}
+
+	if len(f.States) > 0 && !types.Contains(f.States, data.State) {
+		return false
+	}
+
+	if len(f.Labels) > 0 {
+		var found bool
+		for _, l := range data.Labels {
+			if types.Contains(f.Labels, l) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,164 +1,238 @@
 package matrix
 
 import (
+	htmltemplate "html/template"
 	"strings"
 	"text/template"
 
 	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
 	"github.com/Informo/specs-bot/types"
 
 	"github.com/matrix-org/gomatrix"
 	"github.com/sirupsen/logrus"
 )
 
-// Cli is a representation of a Matrix client, containing both the gomatrix
-// client and the configuration.
+// Cli is a representation of a Matrix client, containing the gomatrix client,
+// the configuration and the database.
 type Cli struct {
-	c        *gomatrix.Client
-	cfg      *config.Config
-	prevMsgs map[int64]string
+	c   *gomatrix.Client
+	cfg *config.Config
+	db  *database.Database
 }
 
 // NewCli creates and returns an instance of the Cli structure from the Matrix
-// connection information and the configuration provided.
+// connection information, the configuration and the database provided.
 // Returns an error if the gomatrix client failed to initialise.
 func NewCli(
 	hsURL string, mxid string, accessToken string, cfg *config.Config,
+	db *database.Database,
 ) (cli *Cli, err error) {
 	cli = new(Cli)
 	cli.cfg = cfg
+	cli.db = db
 	cli.c, err = gomatrix.NewClient(hsURL, mxid, accessToken)
-	cli.prevMsgs = make(map[int64]string)
 	return
 }
 
-// SendNoticeWithTypeAndState generates a notice message from the SCS data and
-// then sends the said message as a notice to the configured Matrix rooms.
+// SendNoticeWithMessageKey generates a notice message from the SCS data and
+// the message string located at the given key in the strings file, and then
+// sends the said message as a notice to the configured Matrix rooms. It is
+// meant to be used for notices that aren't sent in response to an update to
+// the SCS, and therefore aren't selected by the notice rules, such as
+// reminders.
+// Returns whether the notice was sent to at least one room.
 // Returns an error if the message could not be generated or if the notice could
 // not be sent to the Matrix rooms.
-// Returns and do nothing if there's no message string available for the SCS's
-// SCSP state.
-func (c *Cli) SendNoticeWithTypeAndState(data *types.SCSData) (err error) {
+// Returns and do nothing if there's no message string at the given key.
+func (c *Cli) SendNoticeWithMessageKey(
+	data *types.SCSData, key types.MessageKey,
+) (sent bool, err error) {
 	logDebugEntry := logrus.WithFields(logrus.Fields{
-		"number": data.Number,
-		"title":  data.Title,
-		"url":    data.URL,
-		"type":   data.Type,
-		"state":  data.State,
+		"repository": data.Repository,
+		"number":     data.Number,
+		"title":      data.Title,
+		"url":        data.URL,
+		"section":    key.Section,
+		"name":       key.Name,
 	})
 
-	// Check if there's a message string available for the given SCS type and
-	// SCSP state.
-	var ok bool
-	data.Message, ok = c.cfg.Notices.Strings[data.Type][data.State]
+	repo, ok := c.cfg.Repository(data.Repository)
 	if !ok {
-		logDebugEntry.Debug("Could not find a type-specific message string, searching into global message strings")
-		// If a message string could not be found for the given SCS type and
-		// SCSP state, check if there's a type-independant message string for
-		// this SCSP state.
-		data.Message, ok = c.cfg.Notices.Strings["global"][data.State]
-
-		// If no string could be found, return and do nothing.
-		if !ok {
-			logDebugEntry.Debug("Could not find a global message string for the given state")
-			return
-		}
+		logDebugEntry.Debug("No configuration for the repository")
+		return
+	}
 
-		logDebugEntry.Debug("Got a global message string")
-	} else {
-		logDebugEntry.Debug("Got a type-specific message string")
+	data.Message, ok = repo.Strings[key.Section][key.Name]
+	if !ok {
+		logDebugEntry.Debug("Could not find a message string for the given key")
+		return
 	}
 
-	return c.sendNotice(data)
+	data.MessageKeys = []types.MessageKey{key}
+
+	return c.sendNotice(repo.Rooms, data, false)
 }
 
-// SendNoticeWithUnsplitLabels generates a notice message from the pull
-// request's or issue's labels that couldn't be split accordingly with Informo's
-// SCSP. It then sends the message to the configured Matrix room. It is meant to
-// be used as a fallback if either the type or the state couldn't be determined
-// (i.e. if the proposal doesn't implement Informo's SCSP).
-// Returns with an error if the notice message could not be generated or sent.
-// Returns and do nothing if there's no message string matching any of the given
-// labels, or if there was more than one match.
-func (c *Cli) SendNoticeWithUnsplitLabels(
-	data *types.SCSData, unsplitLabels []string,
-) (err error) {
-	logDebugEntry := logrus.WithFields(logrus.Fields{
-		"number": data.Number,
-		"title":  data.Title,
-		"url":    data.URL,
-		"labels": unsplitLabels,
+// sendNotice uses the given data to generate the full notice message for this
+// submission update from the configured templates, and send it to the given
+// Matrix rooms. The message is generated once per room, using the room's own
+// strings and templates. If edit is true, the notice announces an update to the
+// submission's state, and is sent as an edit of the previous such notice in the
+// rooms configured to edit previous notices.
+// Returns whether the notice was sent to at least one room, or had already
+// been sent to it by a previous attempt.
+// Returns and do nothing if the latest message sent for this submission is the
+// same as the message for this update.
+// Returns with a SendError listing the rooms the notice couldn't be generated
+// from the room's templates or sent to if there are any, in which case the
+// message isn't saved as the latest message sent.
+// Returns with an error it there was an issue retrieving or saving the latest
+// message sent for this submission, or generating the message from its message
+// string.
+func (c *Cli) sendNotice(
+	rooms []config.RoomConfig, data *types.SCSData, edit bool,
+) (sent bool, err error) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"repository": data.Repository,
+		"number":     data.Number,
+		"title":      data.Title,
+		"url":        data.URL,
+		"message":    data.Message,
+		"type":       data.Type,
+		"state":      data.State,
 	})
 
-	messages := make([]string, 0)
+	// Message strings can themselves be templates (e.g. to include the login
+	// of the user who triggered the update), so compare the generated message
+	// rather than the raw string.
+	expanded, err := expandMessage(data)
+	if err != nil {
+		logEntry.Debug("Could not build message from message string")
+		return
+	}
 
-	var ok bool
-	var match string
-	for _, l := range unsplitLabels {
-		// If we have another match when there's already a message loaded in,
-		// we don't know what message to use. In this case, don't do anything.
-		if match, ok = c.cfg.Notices.Strings["global"][l]; ok && len(data.Message) > 0 {
-			logDebugEntry.WithField("name", l).Debug("Found another message string for label name, aborting")
-			return
-		} else if ok {
-			messages = append(messages, match)
-			logDebugEntry.WithField("name", l).Debug("Found a message string for label name")
+	// Retrieve the latest message sent for this submission from the database,
+	// so we don't send the same update twice, even across restarts.
+	msg, ok, err := c.db.GetLastNotice(data.Repository, data.Number)
+	if err != nil {
+		return
+	}
+
+	if ok && strings.Compare(msg, expanded) == 0 {
+		logEntry.Debug("Already sent this update for this submission")
+		return
+	}
+
+	// Send a notice to the Matrix rooms with the notice message.
+	var body, formattedBody string
+	var sendErr *SendError
+	for _, room := range rooms {
+		roomLogEntry := logEntry.WithField("room_id", room.ID)
+
+		// Skip the rooms which filters don't let this notice through.
+		if !roomAccepts(room, data) {
+			roomLogEntry.Debug("Notice filtered out for room")
+			continue
+		}
+
+		// Look the message up in the room's strings, and skip the room if it
+		// doesn't define it.
+		roomData, ok := localise(room, data)
+		if !ok {
+			roomLogEntry.Debug("Could not find the message string in the room's strings")
+			continue
+		}
+
+		// If the notice message couldn't be built or sent for a specific room,
+		// display the error without breaking from the loop in order to send the
+		// notice to as much rooms possible.
+		if body, formattedBody, err = render(room, roomData); err != nil {
+			roomLogEntry.WithError(err).Error("Could not build notice message from template")
+			sendErr = sendErr.add(room.ID, err)
+			continue
 		}
+
+		if err = c.sendNoticeToRoom(
+			room, roomData, body, formattedBody, edit,
+		); err != nil {
+			roomLogEntry.Error(err)
+			sendErr = sendErr.add(room.ID, err)
+			continue
+		}
+
+		sent = true
 	}
 
-	switch len(messages) {
-	case 0:
-		// No message could be found for any of the labels.
-		logDebugEntry.Debug("Could not find a message for any label name")
+	// Only save the message as the latest one sent for this submission if it
+	// could be sent to every room, so the update isn't skipped if processing
+	// the event is retried.
+	if sendErr != nil {
+		return sent, sendErr
+	}
+
+	if err = c.db.UpdateLastNotice(data.Repository, data.Number, expanded); err != nil {
 		return
-	case 1:
-		// Only 1 message has been found, we don't need to do any copy.
-		data.Message = messages[0]
-		return c.sendNotice(data)
-	default:
-		// More than 1 message has been found found, we copy the structure as much
-		// as necessary with the different messages then send them.
-		var msg string
-		for _, msg = range messages {
-			if err = c.sendNotice(data.CopyWithMsg(msg)); err != nil {
-				return
-			}
+	}
+
+	logEntry.Debug("Notice sent")
+
+	return
+}
+
+// localise returns a copy of the given SCS data with its message looked up in
+// the given room's strings. If the SCS data doesn't specify where to find its
+// message, it is returned as is.
+// Returns false if the message couldn't be found in the room's strings.
+func localise(room config.RoomConfig, data *types.SCSData) (*types.SCSData, bool) {
+	if len(data.MessageKeys) == 0 {
+		return data, true
+	}
+
+	for _, key := range data.MessageKeys {
+		if msg, ok := room.Strings[key.Section][key.Name]; ok {
+			return data.CopyWithMsg(msg), true
 		}
 	}
 
-	return nil
+	return nil, false
 }
 
-// sendNotice uses the given data to generate the full notice message for this
-// submission update from the configured template, and send it to the Matrix
-// rooms.
-// Returns and do nothing if the latest message sent for this submission is the
-// same as the message for this update.
-// Returns with an error it there was an issue generating the notice message
-// from the configured template, or sending it out as a notice to the Matrix
-// room.
-func (c *Cli) sendNotice(data *types.SCSData) (err error) {
-	logEntry := logrus.WithFields(logrus.Fields{
-		"number":  data.Number,
-		"title":   data.Title,
-		"url":     data.URL,
-		"message": data.Message,
-		"type":    data.Type,
-		"state":   data.State,
-	})
+// expandMessage generates the message of the given SCS data by using its
+// message string as a template.
+// Returns with an error if the template couldn't be loaded or executed.
+func expandMessage(data *types.SCSData) (string, error) {
+	tmpl, err := template.New("message").Parse(data.Message)
+	if err != nil {
+		return "", err
+	}
 
-	if msg, ok := c.prevMsgs[data.Number]; ok && strings.Compare(msg, data.Message) == 0 {
-		logEntry.Debug("Already sent this update for this submission")
-		return
+	var b strings.Builder
+	if err = tmpl.Execute(&b, data); err != nil {
+		return "", err
 	}
 
-	c.prevMsgs[data.Number] = data.Message
+	return b.String(), nil
+}
+
+// render generates the notice message for the given SCS data from the given
+// room's template, as well as its formatted version if the room has a HTML
+// template configured (otherwise the formatted version is empty). The SCS
+// data's message string is expanded before being used in the templates.
+// Returns with an error if one of the templates couldn't be loaded or executed.
+func render(room config.RoomConfig, data *types.SCSData) (body string, formattedBody string, err error) {
+	// Generate the message from the message string, and work on a copy of the
+	// SCS data so the original message string is left untouched.
+	msg, err := expandMessage(data)
+	if err != nil {
+		return
+	}
+	data = data.CopyWithMsg(msg)
 
 	// Load the template defined in the configuration file. The "message" name
 	// used here is not important.
-	tmpl, err := template.New("message").Parse(c.cfg.Notices.Pattern)
+	tmpl, err := template.New("message").Parse(room.Pattern)
 	if err != nil {
-		logEntry.Debug("Could not load template")
 		return
 	}
 
@@ -166,23 +240,118 @@ func (c *Cli) sendNotice(data *types.SCSData) (err error) {
 	// data.
 	var b strings.Builder
 	if err = tmpl.Execute(&b, data); err != nil {
-		logEntry.Debug("Could not build notice message from template")
 		return
 	}
+	body = b.String()
 
-	// Send a notice to the Matrix rooms with the notice message.
-	for _, room := range c.cfg.Notices.Rooms {
-		_, err = c.c.SendNotice(room, b.String())
+	// If a HTML template is defined in the configuration file, use it to
+	// generate the formatted version of the notice message, using the plain
+	// text version as the fallback body.
+	if len(room.HTMLPattern) > 0 {
+		var htmlTmpl *htmltemplate.Template
+		if htmlTmpl, err = htmltemplate.New("message").Parse(room.HTMLPattern); err != nil {
+			return
+		}
 
-		// If there is was an error sending the notice to a specific room,
-		// display the error without breaking from the loop in order to send the
-		// notice to as much rooms possible.
-		if err != nil {
-			logEntry.Error(err)
+		var formatted strings.Builder
+		if err = htmlTmpl.Execute(&formatted, data); err != nil {
+			return
 		}
+		formattedBody = formatted.String()
 	}
 
-	logEntry.Debug("Notice sent")
+	return
+}
+
+// sendNoticeToRoom sends the given notice message, along with its formatted
+// version if it isn't empty, to a single Matrix room and records it in the
+// database. If edit is true, the room is configured to edit previous notices
+// and a notice announcing an update to the submission's state has already been
+// sent to it, the message is sent as an edit of the said notice instead of a
+// new one. Otherwise, if threads are enabled, the message is sent in the
+// submission's thread, or becomes the root of this thread if there's none yet
+// in this room.
+// Returns with an error if the notice couldn't be sent or recorded.
+func (c *Cli) sendNoticeToRoom(
+	room config.RoomConfig, data *types.SCSData, body string,
+	formattedBody string, edit bool,
+) (err error) {
+	// Skip the room if this notice is the latest one sent to it for this
+	// submission, which happens if a previous attempt at sending it only
+	// failed for other rooms.
+	latestBody, _, err := c.db.GetLatestNoticeBody(
+		data.Repository, data.Number, room.ID,
+	)
+	if err != nil {
+		return
+	}
+
+	if latestBody == body {
+		logrus.WithFields(logrus.Fields{
+			"repository": data.Repository,
+			"number":     data.Number,
+			"room_id":    room.ID,
+		}).Debug("Notice already sent to room")
+		return
+	}
+
+	var editable string
+	if edit && room.EditPrevious {
+		if editable, _, err = c.db.GetLatestEditableNotice(
+			data.Repository, data.Number, room.ID,
+		); err != nil {
+			return
+		}
+	}
+
+	var latest string
+	if c.cfg.Notices.Threads {
+		if latest, _, err = c.db.GetLatestOriginalNotice(
+			data.Repository, data.Number, room.ID,
+		); err != nil {
+			return
+		}
+	}
+
+	var root string
+	if c.cfg.Notices.Threads {
+		if root, _, err = c.db.GetThreadRoot(
+			data.Repository, data.Number, room.ID,
+		); err != nil {
+			return
+		}
+	}
+
+	var content *noticeContent
+	var replaces string
+	if len(editable) > 0 {
+		replaces = editable
+		content = newEditContent(body, formattedBody, replaces)
+	} else if len(root) > 0 {
+		content = newThreadContent(body, formattedBody, root, latest)
+	} else {
+		content = newNoticeContent(body, formattedBody)
+	}
+
+	resp, err := c.sendMessageEvent(room.ID, content)
+	if err != nil {
+		return
+	}
+
+	if err = c.db.SaveNotice(
+		data.Repository, data.Number, room.ID, resp.EventID, body, data.Type,
+		data.State, replaces, edit,
+	); err != nil {
+		return
+	}
+
+	// If threads are enabled and there was no thread for this submission in
+	// this room yet, the notice we just sent is the root of the thread.
+	if c.cfg.Notices.Threads && len(root) == 0 && len(replaces) == 0 {
+		err = c.db.SaveThreadRoot(
+			data.Repository, data.Number, room.ID, resp.EventID,
+		)
+	}
 
 	return
 }
@@ -0,0 +1,197 @@
+package matrix
+
+import (
+	"strings"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ruleData is the data available to the patterns of the notice rules'
+// messages, i.e. both the SCS data and the update it's about.
+type ruleData struct {
+	*types.SCSData
+	*types.NoticeEvent
+}
+
+// SendNoticeWithRules looks for the first notice rule configured for the SCS's
+// repository that the given update to the SCS described by the given data
+// matches and which selects a message string defined in the repository's
+// strings, then generates a notice message from the SCS data and this message
+// string and sends it to the rooms selected by the rule. A rule matching the
+// update which doesn't select any defined message string is skipped, so that
+// the next rules can be tried. The notice is sent as an edit of the previous
+// one in the rooms configured to do so if the rule allows it.
+// If the rule requires it, the SCS's transition from its previous SCSP state,
+// if known, is checked against the configured state machine first, and
+// reported to the maintainers instead of being announced if it isn't allowed.
+// Returns whether the notice was sent to at least one room.
+// Returns an error if the transition couldn't be reported, if the location of
+// a message string couldn't be generated from a rule, or if the notice message
+// could not be generated or sent.
+// Returns and do nothing if no rule selects a message string for the update.
+func (c *Cli) SendNoticeWithRules(
+	data *types.SCSData, event *types.NoticeEvent,
+) (sent bool, err error) {
+	logDebugEntry := logrus.WithFields(logrus.Fields{
+		"repository": data.Repository,
+		"number":     data.Number,
+		"title":      data.Title,
+		"url":        data.URL,
+		"type":       data.Type,
+		"state":      data.State,
+		"event":      event.Event,
+		"action":     event.Action,
+	})
+
+	repo, ok := c.cfg.Repository(data.Repository)
+	if !ok {
+		logDebugEntry.Debug("No configuration for the repository")
+		return
+	}
+
+	for i := range repo.Rules {
+		rule := &(repo.Rules[i])
+		ruleLogEntry := logDebugEntry.WithFields(logrus.Fields{
+			"rule":  rule.Name,
+			"index": i,
+		})
+
+		if !ruleMatches(rule, data, event) {
+			continue
+		}
+
+		ruleLogEntry.Debug("Update matches notice rule")
+
+		// Check the transition before looking for a message string, so
+		// transitions that aren't allowed are reported even if they wouldn't
+		// have been announced.
+		if rule.CheckTransition && event.HasPreviousState {
+			var allowed bool
+			if allowed, err = c.CheckTransition(
+				data, event.PreviousState,
+			); err != nil || !allowed {
+				return
+			}
+		}
+
+		var keys []types.MessageKey
+		if keys, err = messageKeys(rule, data, event); err != nil {
+			ruleLogEntry.Debug("Could not build message string location from rule")
+			return
+		}
+
+		// Record where the message string can be found in the strings files,
+		// so rooms using a different one can look it up again.
+		for _, key := range keys {
+			if data.Message, ok = repo.Strings[key.Section][key.Name]; ok {
+				ruleLogEntry.WithFields(logrus.Fields{
+					"section": key.Section,
+					"name":    key.Name,
+				}).Debug("Got a message string")
+
+				data.MessageKeys = keys
+				return c.sendNotice(
+					repo.NoticeRooms(rule), data, rule.EditPrevious,
+				)
+			}
+		}
+
+		ruleLogEntry.Debug("Could not find a message string for rule, trying the next one")
+	}
+
+	logDebugEntry.Debug("No notice rule selects a message string for this update")
+
+	return
+}
+
+// ruleMatches checks whether the given update to the SCS described by the
+// given data matches every non-empty setting of the given notice rule.
+func ruleMatches(
+	rule *config.NoticeRule, data *types.SCSData, event *types.NoticeEvent,
+) bool {
+	if len(rule.Events) > 0 && !types.Contains(rule.Events, event.Event) {
+		return false
+	}
+
+	if len(rule.Actions) > 0 && !types.Contains(rule.Actions, event.Action) {
+		return false
+	}
+
+	if len(rule.Labels) > 0 {
+		var found bool
+		for _, l := range data.Labels {
+			if types.Contains(rule.Labels, l) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(rule.Added) > 0 && !types.Contains(rule.Added, event.Added) {
+		return false
+	}
+
+	if len(rule.Removed) > 0 && !types.Contains(rule.Removed, event.Removed) {
+		return false
+	}
+
+	if len(rule.Types) > 0 && !types.Contains(rule.Types, data.Type) {
+		return false
+	}
+
+	if len(rule.States) > 0 && !types.Contains(rule.States, data.State) {
+		return false
+	}
+
+	if len(rule.Authors) > 0 && !types.Contains(rule.Authors, data.Author) {
+		return false
+	}
+
+	if rule.Draft != nil && *rule.Draft != data.Draft {
+		return false
+	}
+
+	scsp := len(data.Type) > 0 && len(data.State) > 0
+	if rule.SCSP != nil && *rule.SCSP != scsp {
+		return false
+	}
+
+	return true
+}
+
+// messageKeys generates the locations in the strings files of the message
+// strings selected by the given notice rule for the given update to the SCS
+// described by the given data, using the rule's messages as templates, which
+// have been parsed when loading the configuration.
+// Messages which location doesn't specify a name are ignored.
+// Returns with an error if one of the templates couldn't be executed.
+func messageKeys(
+	rule *config.NoticeRule, data *types.SCSData, event *types.NoticeEvent,
+) (keys []types.MessageKey, err error) {
+	keys = make([]types.MessageKey, 0, len(rule.Messages))
+
+	for _, tmpl := range rule.MessageTemplates() {
+		var b strings.Builder
+		if err = tmpl.Execute(&b, &ruleData{data, event}); err != nil {
+			return
+		}
+
+		// Names can contain slashes (e.g. labels named after a grammar using
+		// them as separators), but sections can't.
+		split := strings.SplitN(b.String(), "/", 2)
+		if len(split) < 2 || len(split[1]) == 0 {
+			continue
+		}
+
+		keys = append(keys, types.MessageKey{Section: split[0], Name: split[1]})
+	}
+
+	return
+}
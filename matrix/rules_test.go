@@ -0,0 +1,157 @@
+package matrix
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/types"
+)
+
+// loadDefaultRules loads a minimal configuration file that doesn't define any
+// notice rule, and returns the default notice rules it's been filled with.
+func loadDefaultRules(t *testing.T) []config.NoticeRule {
+	f, err := ioutil.TempFile("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.WriteString("database:\n  driver: sqlite3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg.Notices.Rules
+}
+
+// TestDefaultRules checks that the default notice rules select the same message
+// strings as the fixed type/state to message mapping they replaced.
+func TestDefaultRules(t *testing.T) {
+	rules := loadDefaultRules(t)
+
+	tests := []struct {
+		name  string
+		data  types.SCSData
+		event types.NoticeEvent
+		// rule is the name of the first rule the update matches, empty if it
+		// doesn't match any.
+		rule string
+		keys []types.MessageKey
+	}{
+		{
+			name:  "SCSP state label added to a PR",
+			data:  types.SCSData{Type: "feature", State: "pending"},
+			event: types.NoticeEvent{Event: "pull_request", Action: "labeled", Added: "scsp:pending"},
+			rule:  "scsp",
+			keys: []types.MessageKey{
+				{Section: "feature", Name: "pending"},
+				{Section: "global", Name: "pending"},
+			},
+		},
+		{
+			name:  "SCSP type label added to an issue",
+			data:  types.SCSData{Type: "behaviour", State: "review"},
+			event: types.NoticeEvent{Event: "issues", Action: "labeled", Added: "type:behaviour"},
+			rule:  "scsp",
+			keys: []types.MessageKey{
+				{Section: "behaviour", Name: "review"},
+				{Section: "global", Name: "review"},
+			},
+		},
+		{
+			name:  "label removed from a proposal",
+			data:  types.SCSData{Type: "feature", State: "pending"},
+			event: types.NoticeEvent{Event: "pull_request", Action: "unlabeled", Removed: "scsp:fcp"},
+			rule:  "unlabeled",
+			keys: []types.MessageKey{
+				{Section: "unlabeled", Name: "scsp:fcp"},
+			},
+		},
+		{
+			name:  "label added to a proposal without SCSP labels",
+			data:  types.SCSData{Labels: []string{"wontfix"}},
+			event: types.NoticeEvent{Event: "issues", Action: "labeled", Added: "wontfix"},
+			rule:  "generic",
+			keys: []types.MessageKey{
+				{Section: "global", Name: "wontfix"},
+			},
+		},
+		{
+			name:  "PR opened",
+			event: types.NoticeEvent{Event: "pull_request", Action: "opened"},
+			rule:  "pull_request",
+			keys: []types.MessageKey{
+				{Section: "pull_request", Name: "opened"},
+				{Section: "global", Name: "opened"},
+			},
+		},
+		{
+			name:  "PR merged",
+			data:  types.SCSData{Type: "feature", State: "fcp"},
+			event: types.NoticeEvent{Event: "pull_request", Action: "merged"},
+			rule:  "pull_request",
+			keys: []types.MessageKey{
+				{Section: "pull_request", Name: "merged"},
+				{Section: "global", Name: "merged"},
+			},
+		},
+		{
+			name:  "PR approved",
+			event: types.NoticeEvent{Event: "pull_request_review", Action: "approved"},
+			rule:  "review",
+			keys: []types.MessageKey{
+				{Section: "review", Name: "approved"},
+			},
+		},
+		{
+			name:  "issue closed",
+			event: types.NoticeEvent{Event: "issues", Action: "closed"},
+		},
+		{
+			name:  "PR edited",
+			event: types.NoticeEvent{Event: "pull_request", Action: "edited"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rule *config.NoticeRule
+			for i := range rules {
+				if ruleMatches(&rules[i], &tt.data, &tt.event) {
+					rule = &rules[i]
+					break
+				}
+			}
+
+			if rule == nil {
+				if len(tt.rule) > 0 {
+					t.Fatalf("update doesn't match any rule, expected %q", tt.rule)
+				}
+				return
+			}
+
+			if rule.Name != tt.rule {
+				t.Fatalf("update matches rule %q, expected %q", rule.Name, tt.rule)
+			}
+
+			keys, err := messageKeys(rule, &tt.data, &tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("got message keys %v, expected %v", keys, tt.keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+	"github.com/sirupsen/logrus"
+)
+
+// SendError is returned when a message couldn't be sent to some of the Matrix
+// rooms, even after retrying. It contains the error for each of these rooms.
+type SendError struct {
+	FailedRooms map[string]error
+}
+
+// Error implements error.
+func (e *SendError) Error() string {
+	rooms := make([]string, 0, len(e.FailedRooms))
+	for room := range e.FailedRooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	errs := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		errs = append(errs, fmt.Sprintf("%s: %v", room, e.FailedRooms[room]))
+	}
+
+	return fmt.Sprintf(
+		"Failed to send message to %d room(s): %s", len(rooms),
+		strings.Join(errs, "; "),
+	)
+}
+
+// add records the error for a room, and returns the SendError so it can be
+// instantiated lazily.
+func (e *SendError) add(room string, err error) *SendError {
+	if e == nil {
+		e = &SendError{FailedRooms: make(map[string]error)}
+	}
+
+	e.FailedRooms[room] = err
+	return e
+}
+
+// errOrNil returns the SendError as an error, or nil if it's nil. This avoids
+// returning a non-nil error interface holding a nil pointer.
+func (e *SendError) errOrNil() error {
+	if e == nil {
+		return nil
+	}
+
+	return e
+}
+
+// sendMessageEvent sends a m.room.message event with the given content to the
+// given room. If the request fails because of a rate limit, a server error or
+// a network error, it is retried up to the configured number of attempts,
+// waiting for the delay requested by the homeserver for rate limits and with
+// an exponential backoff otherwise. The same transaction ID is used for every
+// attempt, so the homeserver doesn't send the event twice.
+// Returns with an error if the event couldn't be sent after the last attempt,
+// or if the homeserver rejected it for a reason retrying won't fix.
+func (c *Cli) sendMessageEvent(
+	roomID string, content interface{},
+) (resp *gomatrix.RespSendEvent, err error) {
+	txnID := "specsbot" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	url := c.c.BuildURL("rooms", roomID, "send", "m.room.message", txnID)
+
+	backoff := c.cfg.Matrix.SendBackoff
+	for attempt := 1; ; attempt++ {
+		resp = nil
+		if err = c.c.MakeRequest("PUT", url, content, &resp); err == nil {
+			return
+		}
+
+		delay, retry := retryDelay(err, backoff)
+		if !retry || attempt >= c.cfg.Matrix.SendAttempts {
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"room_id": roomID,
+			"attempt": attempt,
+			"delay":   delay,
+			"error":   err,
+		}).Warn("Failed to send message, retrying")
+
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
+
+// retryDelay checks whether a request that failed with the given error should
+// be retried, and returns the delay to wait before retrying it. Rate-limited
+// requests are retried after the delay requested by the homeserver (or the
+// given backoff if none was provided), requests which failed because of a
+// server or network error are retried after the given backoff, and other
+// requests aren't retried.
+func retryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	httpErr, ok := err.(gomatrix.HTTPError)
+	if !ok {
+		// The request didn't get a response, e.g. because of a network error.
+		return backoff, true
+	}
+
+	var respErr struct {
+		ErrCode      string `json:"errcode"`
+		RetryAfterMS int64  `json:"retry_after_ms"`
+	}
+	_ = json.Unmarshal(httpErr.Contents, &respErr)
+
+	switch {
+	case httpErr.Code == http.StatusTooManyRequests || respErr.ErrCode == "M_LIMIT_EXCEEDED":
+		if respErr.RetryAfterMS > 0 {
+			return time.Duration(respErr.RetryAfterMS) * time.Millisecond, true
+		}
+		return backoff, true
+	case httpErr.Code >= 500:
+		return backoff, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package matrix
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrix"
+)
+
+func TestRetryDelay(t *testing.T) {
+	backoff := 2 * time.Second
+
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		retry bool
+	}{
+		{
+			name:  "network error",
+			err:   errors.New("connection refused"),
+			delay: backoff,
+			retry: true,
+		},
+		{
+			name: "rate limited with delay",
+			err: gomatrix.HTTPError{
+				Code:     429,
+				Contents: []byte(`{"errcode": "M_LIMIT_EXCEEDED", "retry_after_ms": 1500}`),
+			},
+			delay: 1500 * time.Millisecond,
+			retry: true,
+		},
+		{
+			name:  "rate limited without delay",
+			err:   gomatrix.HTTPError{Code: 429},
+			delay: backoff,
+			retry: true,
+		},
+		{
+			name: "rate limit error code",
+			err: gomatrix.HTTPError{
+				Code:     400,
+				Contents: []byte(`{"errcode": "M_LIMIT_EXCEEDED"}`),
+			},
+			delay: backoff,
+			retry: true,
+		},
+		{
+			name:  "server error",
+			err:   gomatrix.HTTPError{Code: 502},
+			delay: backoff,
+			retry: true,
+		},
+		{
+			name: "forbidden",
+			err: gomatrix.HTTPError{
+				Code:     403,
+				Contents: []byte(`{"errcode": "M_FORBIDDEN"}`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := retryDelay(tt.err, backoff)
+			if delay != tt.delay || retry != tt.retry {
+				t.Errorf(
+					"got (%v, %v), expected (%v, %v)",
+					delay, retry, tt.delay, tt.retry,
+				)
+			}
+		})
+	}
+}
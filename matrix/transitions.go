@@ -0,0 +1,72 @@
+package matrix
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CheckTransition checks whether the SCS described by the given data is
+// allowed to go from the given SCSP state to its current one, according to the
+// state machine configured for its type. If it isn't, the transition is
+// reported to the maintainers' room if one is configured.
+// Returns whether the transition is allowed.
+// Returns with an error if the report couldn't be generated from the
+// configured template or sent.
+func (c *Cli) CheckTransition(
+	data *types.SCSData, from string,
+) (allowed bool, err error) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"repository": data.Repository,
+		"number":     data.Number,
+		"type":       data.Type,
+		"from":       from,
+		"to":         data.State,
+	})
+
+	cfg := c.cfg.Transitions
+	if cfg.Allowed(data.Type, from, data.State) {
+		return true, nil
+	}
+
+	logEntry.Warn("Transition not allowed")
+
+	if len(cfg.MaintainersRoom) == 0 {
+		logEntry.Debug("No maintainers room configured, not reporting transition")
+		return
+	}
+
+	tmpl, err := template.New("transition").Parse(cfg.Pattern)
+	if err != nil {
+		logEntry.Debug("Could not load template")
+		return
+	}
+
+	var b strings.Builder
+	if err = tmpl.Execute(&b, &types.TransitionData{
+		Repository: data.Repository,
+		Number:     data.Number,
+		Title:      data.Title,
+		URL:        data.URL,
+		Type:       data.Type,
+		From:       from,
+		To:         data.State,
+		Actor:      data.Actor,
+	}); err != nil {
+		logEntry.Debug("Could not build transition report from template")
+		return
+	}
+
+	if _, err = c.sendMessageEvent(
+		cfg.MaintainersRoom, newNoticeContent(b.String(), ""),
+	); err != nil {
+		return
+	}
+
+	logEntry.Debug("Transition reported")
+
+	return
+}
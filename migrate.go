@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+)
+
+// Actions of the migrate subcommand.
+const (
+	migrateRun    = "run"
+	migrateStatus = "status"
+	migrateDryRun = "dry-run"
+)
+
+// runMigrate implements the migrate subcommand, which either applies the
+// pending migrations of the database's schema ("run"), lists every migration
+// along with whether it has been applied ("status"), or lists the pending
+// migrations along with the statements they would run without applying them
+// ("dry-run"). The action defaults to "run" if empty.
+// Returns an error if the action isn't supported or if there was an issue
+// talking to the database.
+func runMigrate(cfg *config.Config, action string) error {
+	if len(action) == 0 {
+		action = migrateRun
+	}
+
+	if action != migrateRun && action != migrateStatus && action != migrateDryRun {
+		return fmt.Errorf(
+			"Unsupported migrate action %q, expected one of: %s", action,
+			strings.Join([]string{migrateRun, migrateStatus, migrateDryRun}, ", "),
+		)
+	}
+
+	m, err := database.NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+
+	if action == migrateStatus {
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied && s.AppliedAt.IsZero() {
+				state = "applied"
+			} else if s.Applied {
+				state = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d\t%s\t%s\n", s.Version, s.Description, state)
+		}
+
+		return nil
+	}
+
+	pending, err := m.Migrate(action == migrateDryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("The database is up to date")
+		return nil
+	}
+
+	for _, s := range pending {
+		if action == migrateRun {
+			fmt.Printf("Applied migration %d: %s\n", s.Version, s.Description)
+			continue
+		}
+
+		fmt.Printf("Migration %d: %s\n", s.Version, s.Description)
+		for _, statement := range s.Statements {
+			fmt.Printf("%s;\n", strings.TrimSpace(statement))
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
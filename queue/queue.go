@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxBackoff is the maximum delay between two attempts at processing a job.
+const maxBackoff = time.Hour
+
+// Handler processes the JSON-encoded payload of a given GitHub event, which
+// comes from the webhook delivery with the given GUID, if known.
+type Handler func(event string, deliveryID string, payload []byte) error
+
+// Queue is a durable queue of webhook payloads, backed by the database and
+// processed by a pool of workers.
+type Queue struct {
+	db      *database.Database
+	cfg     config.QueueConfig
+	handler Handler
+	jobs    chan types.Job
+	wake    chan struct{}
+}
+
+// NewQueue creates and returns an instance of the Queue structure, which will
+// use the given handler to process the jobs.
+func NewQueue(
+	cfg *config.Config, db *database.Database, handler Handler,
+) *Queue {
+	return &Queue{
+		db:      db,
+		cfg:     cfg.Queue,
+		handler: handler,
+		jobs:    make(chan types.Job),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Start resumes the jobs interrupted by a previous stop of the bot, then starts
+// the workers and the goroutine dispatching the jobs to them.
+// Returns an error if the interrupted jobs couldn't be resumed.
+func (q *Queue) Start() error {
+	if err := q.db.ResumeJobs(); err != nil {
+		return err
+	}
+
+	for i := 0; i < q.cfg.Workers; i++ {
+		go q.work()
+	}
+
+	go q.dispatch()
+
+	return nil
+}
+
+// Enqueue stores the given payload in the database so it can be processed by
+// the workers. The delivery's GUID is used to identify the job if provided.
+// The payloads about the same proposal are processed in the order they were
+// stored in, one at a time.
+// Returns an error if the payload couldn't be encoded or stored.
+func (q *Queue) Enqueue(
+	deliveryID string, event string, payload interface{},
+) (err error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	id := deliveryID
+	if len(id) == 0 {
+		if id, err = randomID(); err != nil {
+			return
+		}
+	}
+
+	repository, number := payloadProposal(encoded)
+	if err = q.db.EnqueueJob(
+		id, deliveryID, event, encoded, repository, number,
+	); err != nil {
+		return
+	}
+
+	// Wake the dispatcher up so it doesn't wait for the next poll to pick the
+	// job up. Don't block if it has already been woken up.
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return
+}
+
+// dispatch regularly claims the jobs which are due and sends them to the
+// workers. It is meant to be run in its own goroutine.
+func (q *Queue) dispatch() {
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		jobs, err := q.db.ClaimDueJobs(q.cfg.Workers)
+		if err != nil {
+			logrus.Error(err)
+		}
+
+		for _, job := range jobs {
+			q.jobs <- job
+		}
+
+		// If there might be more due jobs, don't wait before claiming them.
+		if len(jobs) == q.cfg.Workers {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// work processes the jobs sent by the dispatcher. It is meant to be run in its
+// own goroutine.
+func (q *Queue) work() {
+	for job := range q.jobs {
+		q.process(job)
+	}
+}
+
+// process processes a job with the queue's handler and records the result. If
+// the handler returned with an error, the job is retried later with an
+// exponential backoff, until the maximum number of attempts is reached.
+func (q *Queue) process(job types.Job) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"job_id":      job.ID,
+		"delivery_id": job.DeliveryID,
+		"event":       job.Event,
+	})
+
+	job.Attempts++
+	logEntry = logEntry.WithField("attempt", job.Attempts)
+	logEntry.Debug("Processing job")
+
+	jobErr := q.handler(job.Event, job.DeliveryID, job.Payload)
+
+	var err error
+	outcome := database.DeliverySucceeded
+	if jobErr == nil {
+		err = q.db.CompleteJob(job)
+	} else if job.Attempts < q.cfg.MaxAttempts {
+		logEntry.WithField("error", jobErr).Warn("Job failed, retrying later")
+		err = q.db.RetryJob(job, time.Now().Add(q.backoff(job.Attempts)), jobErr)
+		// The delivery isn't done processing yet.
+		outcome = ""
+	} else {
+		logEntry.Error(jobErr)
+		err = q.db.FailJob(job, jobErr)
+		outcome = database.DeliveryFailed
+	}
+
+	if err != nil {
+		logEntry.Error(err)
+	}
+
+	// Save the outcome of the processing of the delivery, so we know whether
+	// to process it again if it's sent again.
+	if len(job.DeliveryID) > 0 && len(outcome) > 0 {
+		if err = q.db.SaveDelivery(job.DeliveryID, job.Event, outcome); err != nil {
+			logEntry.Error(err)
+		}
+	}
+}
+
+// backoff computes the delay to wait before the next attempt at processing a
+// job, given the number of attempts already made.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.cfg.Backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+// payloadProposal returns the full name of the repository and the number of
+// the proposal (i.e. issue or pull request) the given JSON-encoded payload is
+// about. The repository is empty if the payload isn't about a proposal.
+func payloadProposal(payload []byte) (string, int64) {
+	var pl struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Issue struct {
+			Number int64 `json:"number"`
+		} `json:"issue"`
+		PullRequest struct {
+			Number int64 `json:"number"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(payload, &pl); err != nil {
+		return "", 0
+	}
+
+	number := pl.Issue.Number
+	if number == 0 {
+		number = pl.PullRequest.Number
+	}
+
+	if number == 0 {
+		return "", 0
+	}
+
+	return pl.Repository.FullName, number
+}
+
+// randomID generates a random identifier for a job.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Informo/specs-bot/config"
+)
+
+func TestBackoff(t *testing.T) {
+	q := &Queue{cfg: config.QueueConfig{Backoff: 10 * time.Minute}}
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 10 * time.Minute},
+		{2, 20 * time.Minute},
+		{3, 40 * time.Minute},
+		{4, time.Hour},
+		{10, time.Hour},
+		// Make sure the delay doesn't overflow after many attempts.
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if delay := q.backoff(tt.attempts); delay != tt.expected {
+			t.Errorf(
+				"backoff after %d attempt(s): got %v, expected %v",
+				tt.attempts, delay, tt.expected,
+			)
+		}
+	}
+}
+
+func TestPayloadProposal(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		repository string
+		number     int64
+	}{
+		{
+			name:       "issue",
+			payload:    `{"repository": {"full_name": "Informo/specs"}, "issue": {"number": 12}}`,
+			repository: "Informo/specs",
+			number:     12,
+		},
+		{
+			name:       "pull request",
+			payload:    `{"repository": {"full_name": "Informo/specs"}, "pull_request": {"number": 34}}`,
+			repository: "Informo/specs",
+			number:     34,
+		},
+		{
+			name:    "not about a proposal",
+			payload: `{"repository": {"full_name": "Informo/specs"}, "zen": "Keep it simple."}`,
+		},
+		{
+			name:    "invalid JSON",
+			payload: `{"repository"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, number := payloadProposal([]byte(tt.payload))
+			if repository != tt.repository || number != tt.number {
+				t.Errorf(
+					"got (%q, %d), expected (%q, %d)",
+					repository, number, tt.repository, tt.number,
+				)
+			}
+		})
+	}
+}
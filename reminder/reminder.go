@@ -0,0 +1,173 @@
+package reminder
+
+import (
+	"time"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/matrix"
+	"github.com/Informo/specs-bot/mutex"
+	"github.com/Informo/specs-bot/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Section of the strings files containing the reminders' message strings, which
+// are named after the type and the SCSP state of the proposal, separated by a
+// slash (e.g. "behaviour/review").
+const stringsSection = "reminder"
+
+// Actions of the pull request events that close a proposal.
+var closingActions = map[string]bool{
+	"closed": true,
+	"merged": true,
+}
+
+// Scheduler schedules the reminders configured for the proposals' SCSP states,
+// and sends them to the Matrix rooms once they're due.
+type Scheduler struct {
+	cfg config.RemindersConfig
+	db  *database.Database
+	cli *matrix.Cli
+}
+
+// NewScheduler creates and returns an instance of the Scheduler structure.
+func NewScheduler(
+	cfg *config.Config, db *database.Database, cli *matrix.Cli,
+) *Scheduler {
+	return &Scheduler{
+		cfg: cfg.Reminders,
+		db:  db,
+		cli: cli,
+	}
+}
+
+// Start starts the goroutine regularly sending the reminders that are due.
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Schedule updates the reminder of a proposal after the given event happened
+// to it. If a reminder is configured for the proposal's new type and SCSP state,
+// it is scheduled for the configured delay after the event, unless a reminder
+// has already been scheduled for this type and state. Otherwise, or if the
+// proposal is closed, any reminder scheduled for the proposal is cancelled.
+// Returns an error if we couldn't talk to the database.
+func (s *Scheduler) Schedule(data *types.SCSData, event types.ProposalEvent) error {
+	rule, ok := s.cfg.Reminder(data.Type, data.State)
+	if !ok || data.Closed || closingActions[event.Action] {
+		return s.Cancel(data.Repository, data.Number)
+	}
+
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	return s.db.ScheduleReminder(types.Reminder{
+		Repository: data.Repository,
+		Number:     data.Number,
+		Type:       data.Type,
+		State:      data.State,
+		Title:      data.Title,
+		URL:        data.URL,
+		DueAt:      occurredAt.Add(rule.Delay),
+	})
+}
+
+// Cancel cancels the reminder scheduled for a given proposal of a given
+// repository, if any, e.g. because it has been closed.
+// Returns an error if we couldn't talk to the database.
+func (s *Scheduler) Cancel(repository string, number int64) error {
+	return s.db.CancelReminder(repository, number)
+}
+
+// run regularly sends the reminders that are due. It is meant to be run in its
+// own goroutine.
+func (s *Scheduler) run() {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		reminders, err := s.db.GetDueReminders()
+		if err != nil {
+			logrus.Error(err)
+		}
+
+		for _, reminder := range reminders {
+			if err = s.send(reminder); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"repository": reminder.Repository,
+					"number":     reminder.Number,
+				}).Error(err)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// send sends the given reminder to the Matrix rooms using the message string
+// defined for the proposal's type and SCSP state in the "reminder" section of
+// the strings file, then marks it as sent. If there's no such message string,
+// the reminder is marked as sent without sending anything. Does nothing if the
+// reminder has been cancelled, replaced or sent since it was retrieved.
+// Returns an error if the reminder or the proposal's labels couldn't be
+// retrieved, if the reminder couldn't be sent or if it couldn't be marked as
+// sent, in which case it will be tried again later.
+func (s *Scheduler) send(reminder types.Reminder) (err error) {
+	// Lock the mutex for this proposal in order to make sure it doesn't get
+	// updated by an event while we're sending the reminder.
+	mutex.Lock(reminder.Repository, reminder.Number)
+	defer mutex.Unlock(reminder.Repository, reminder.Number)
+
+	logEntry := logrus.WithFields(logrus.Fields{
+		"repository": reminder.Repository,
+		"number":     reminder.Number,
+		"type":       reminder.Type,
+		"state":      reminder.State,
+		"due_at":     reminder.DueAt,
+	})
+
+	// An event could have updated the proposal between the moment the
+	// reminder was retrieved and the moment we got the lock, so check that
+	// it's still the one to send.
+	pending, ok, err := s.db.GetPendingReminder(reminder.Repository, reminder.Number)
+	if err != nil {
+		return
+	}
+
+	if !ok || pending.Type != reminder.Type || pending.State != reminder.State ||
+		!pending.DueAt.Equal(reminder.DueAt) {
+		logEntry.Debug("Reminder cancelled, replaced or sent since it was retrieved")
+		return
+	}
+
+	logEntry.Info("Sending reminder")
+
+	// Retrieve the proposal's labels so they can be used by the rooms'
+	// filters.
+	labels, err := s.db.GetProposalState(reminder.Repository, reminder.Number)
+	if err != nil {
+		return
+	}
+
+	data := &types.SCSData{
+		Repository: reminder.Repository,
+		Number:     reminder.Number,
+		Title:      reminder.Title,
+		Type:       reminder.Type,
+		State:      reminder.State,
+		URL:        reminder.URL,
+		Labels:     labels,
+	}
+
+	if _, err = s.cli.SendNoticeWithMessageKey(data, types.MessageKey{
+		Section: stringsSection,
+		Name:    reminder.Type + "/" + reminder.State,
+	}); err != nil {
+		return
+	}
+
+	return s.db.MarkReminderSent(reminder)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Informo/specs-bot/config"
+	"github.com/Informo/specs-bot/database"
+	"github.com/Informo/specs-bot/report"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runReport implements the report subcommand, which prints the report on the
+// time the proposals of the given repository spent in each SCSP state, or the
+// reports for every repository named in the configuration file if no
+// repository is given.
+// Returns an error if no repository is given nor named in the configuration
+// file, or if there was an issue talking to the database.
+func runReport(cfg *config.Config, repository string) error {
+	repos := []string{repository}
+	if len(repository) == 0 {
+		repos = configuredRepositories(cfg)
+	}
+
+	if len(repos) == 0 {
+		return fmt.Errorf("No repository given nor named in the configuration file")
+	}
+
+	db, err := database.NewDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		events, err := db.GetRepositoryEvents(repo)
+		if err != nil {
+			return err
+		}
+
+		printReport(report.Generate(
+			repo, events, time.Now(), cfg.Reports.StuckLimit,
+		))
+	}
+
+	return nil
+}
+
+// printReport prints the given report in a human-readable way.
+func printReport(r *report.Report) {
+	fmt.Printf(
+		"Report for %s, generated at %s\n\n", r.Repository,
+		r.GeneratedAt.Format("2006-01-02 15:04:05"),
+	)
+
+	fmt.Println("Per type:")
+	for _, t := range r.Types {
+		fmt.Printf("  %s (%d proposals)\n", typeName(t.Type), t.Proposals)
+		states := make([]string, 0, len(t.States))
+		for state := range t.States {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+
+		for _, state := range states {
+			s := t.States[state]
+			fmt.Printf(
+				"    %s: median %s, mean %s, max %s (%d proposals)\n", state,
+				s.Median, s.Mean, s.Max, s.Proposals,
+			)
+		}
+		if t.MedianPendingToMerged != nil {
+			fmt.Printf("    median from pending to merged: %s\n", *t.MedianPendingToMerged)
+		}
+	}
+
+	fmt.Println("\nStuck proposals:")
+	for _, p := range r.Stuck {
+		fmt.Printf(
+			"  #%d (%s): %s for %s\n", p.Number, typeName(p.Type), p.State,
+			report.Duration(r.GeneratedAt.Sub(p.InStateSince)),
+		)
+	}
+
+	fmt.Println("\nProposals:")
+	for _, p := range r.Proposals {
+		times := make([]string, 0, len(p.TimeInStates))
+		for state, d := range p.TimeInStates {
+			times = append(times, fmt.Sprintf("%s %s", state, d))
+		}
+		sort.Strings(times)
+
+		line := fmt.Sprintf(
+			"  #%d (%s): %s", p.Number, typeName(p.Type), strings.Join(times, ", "),
+		)
+		if p.PendingToMerged != nil {
+			line += fmt.Sprintf("; pending to merged: %s", *p.PendingToMerged)
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+}
+
+// reportHandler returns the HTTP handler serving the reports in JSON. The
+// repository to report on is given by the "repository" query parameter, and
+// can be omitted if a single repository is named in the configuration file.
+// The number of stuck proposals to list can be given by the "stuck" query
+// parameter, and defaults to the configured one.
+func reportHandler(
+	cfg *config.Config, db *database.Database,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		repo := r.URL.Query().Get("repository")
+		if len(repo) == 0 {
+			if repos := configuredRepositories(cfg); len(repos) == 1 {
+				repo = repos[0]
+			}
+		}
+
+		if len(repo) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Missing repository")
+			return
+		}
+
+		if _, ok := cfg.Repository(repo); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Unsupported repository %q\n", repo)
+			return
+		}
+
+		stuck := cfg.Reports.StuckLimit
+		if rawStuck := r.URL.Query().Get("stuck"); len(rawStuck) > 0 {
+			var err error
+			if stuck, err = strconv.Atoi(rawStuck); err != nil || stuck < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Invalid number of stuck proposals %q\n", rawStuck)
+				return
+			}
+		}
+
+		events, err := db.GetRepositoryEvents(repo)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(
+			report.Generate(repo, events, time.Now(), stuck),
+		); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// configuredRepositories returns the names of the repositories named in the
+// configuration file.
+func configuredRepositories(cfg *config.Config) []string {
+	repos := make([]string, 0, len(cfg.Repositories))
+	for _, repo := range cfg.Repositories {
+		if len(repo.Name) > 0 {
+			repos = append(repos, repo.Name)
+		}
+	}
+
+	return repos
+}
+
+// typeName returns the given proposal type, or a placeholder if it's empty.
+func typeName(t string) string {
+	if len(t) == 0 {
+		return "unknown type"
+	}
+
+	return t
+}
@@ -0,0 +1,278 @@
+package report
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+// SCSP states and pull request actions used to compute the reports.
+const (
+	statePending   = "pending"
+	stateMerged    = "merged"
+	stateWontMerge = "won't merge"
+	actionMerged   = "merged"
+	actionClosed   = "closed"
+	actionReopened = "reopened"
+)
+
+// finalStates are the SCSP states a proposal doesn't leave once it has reached
+// them, and which therefore aren't timed.
+var finalStates = map[string]bool{
+	stateMerged:    true,
+	stateWontMerge: true,
+}
+
+// Duration is a time.Duration that is encoded in JSON as a number of seconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(time.Duration(d)/time.Second), 10)), nil
+}
+
+// String implements fmt.Stringer, rounding the duration to the second.
+func (d Duration) String() string {
+	return time.Duration(d).Round(time.Second).String()
+}
+
+// ProposalReport describes how long a proposal has spent in each of the SCSP
+// states it went through. State is the proposal's current SCSP state, and
+// InStateSince the time at which it entered it. The time spent in the current
+// state is counted up to the time the report was generated, unless the state is
+// a final one or the proposal is closed. PendingToMerged is the time between
+// the proposal first entering the "pending" state and it being merged, and is
+// nil if either didn't happen.
+type ProposalReport struct {
+	Number          int64               `json:"number"`
+	Type            string              `json:"type"`
+	State           string              `json:"state"`
+	InStateSince    time.Time           `json:"in_state_since"`
+	Closed          bool                `json:"closed"`
+	TimeInStates    map[string]Duration `json:"time_in_states"`
+	PendingToMerged *Duration           `json:"pending_to_merged,omitempty"`
+}
+
+// StateStats aggregates the time spent in a SCSP state by the proposals that
+// went through it.
+type StateStats struct {
+	Proposals int      `json:"proposals"`
+	Mean      Duration `json:"mean"`
+	Median    Duration `json:"median"`
+	Max       Duration `json:"max"`
+}
+
+// TypeReport aggregates the reports of the proposals of a given type.
+// MedianPendingToMerged is nil if no proposal of this type went from "pending"
+// to merged.
+type TypeReport struct {
+	Type                  string                `json:"type"`
+	Proposals             int                   `json:"proposals"`
+	States                map[string]StateStats `json:"states"`
+	MedianPendingToMerged *Duration             `json:"median_pending_to_merged,omitempty"`
+}
+
+// Report describes how long the proposals of a repository spent in each SCSP
+// state, per proposal and aggregated per type, along with the open proposals
+// that have been stuck in their current state for the longest time.
+type Report struct {
+	Repository  string           `json:"repository"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Proposals   []ProposalReport `json:"proposals"`
+	Types       []TypeReport     `json:"types"`
+	Stuck       []ProposalReport `json:"stuck"`
+}
+
+// Generate computes the report for the given repository from the history of
+// its proposals, which must be ordered by proposal then oldest event first. The
+// time spent in the states the proposals are still in is counted up to the
+// given time. Stuck lists at most the given number of proposals.
+func Generate(
+	repository string, events []types.ProposalEvent, now time.Time, stuck int,
+) *Report {
+	r := &Report{
+		Repository:  repository,
+		GeneratedAt: now,
+		Proposals:   make([]ProposalReport, 0),
+		Types:       make([]TypeReport, 0),
+		Stuck:       make([]ProposalReport, 0),
+	}
+
+	for start := 0; start < len(events); {
+		end := start
+		for end < len(events) && events[end].Number == events[start].Number {
+			end++
+		}
+
+		r.Proposals = append(r.Proposals, proposalReport(events[start:end], now))
+		start = end
+	}
+
+	r.Types = typeReports(r.Proposals)
+	r.Stuck = stuckProposals(r.Proposals, stuck)
+
+	return r
+}
+
+// proposalReport computes the report of a proposal from its history, oldest
+// event first.
+func proposalReport(events []types.ProposalEvent, now time.Time) ProposalReport {
+	p := ProposalReport{
+		Number:       events[0].Number,
+		TimeInStates: make(map[string]Duration),
+	}
+
+	// since is the time from which the time spent in the current state is
+	// counted, which differs from InStateSince if the proposal was closed then
+	// reopened while in this state.
+	var since, pendingAt, mergedAt time.Time
+
+	// stop adds the time spent in the current state up to the given time, if
+	// it's being timed.
+	stop := func(at time.Time) {
+		if len(p.State) == 0 || finalStates[p.State] || p.Closed {
+			return
+		}
+		p.TimeInStates[p.State] += Duration(at.Sub(since))
+	}
+
+	for _, e := range events {
+		if len(e.Type) > 0 {
+			p.Type = e.Type
+		}
+
+		if e.State != p.State {
+			stop(e.OccurredAt)
+			p.State = e.State
+			p.InStateSince = e.OccurredAt
+			since = e.OccurredAt
+		}
+
+		switch e.Action {
+		case actionMerged, actionClosed:
+			stop(e.OccurredAt)
+			p.Closed = true
+		case actionReopened:
+			if p.Closed {
+				p.Closed = false
+				since = e.OccurredAt
+			}
+		}
+
+		if p.State == statePending && pendingAt.IsZero() {
+			pendingAt = e.OccurredAt
+		}
+		if (p.State == stateMerged || e.Action == actionMerged) && mergedAt.IsZero() {
+			mergedAt = e.OccurredAt
+		}
+	}
+
+	stop(now)
+
+	if !pendingAt.IsZero() && !mergedAt.IsZero() && !mergedAt.Before(pendingAt) {
+		d := Duration(mergedAt.Sub(pendingAt))
+		p.PendingToMerged = &d
+	}
+
+	return p
+}
+
+// typeReports aggregates the given proposals' reports per type, ordered by
+// type. Proposals which type is unknown are aggregated under an empty type.
+func typeReports(proposals []ProposalReport) []TypeReport {
+	byType := make(map[string][]ProposalReport)
+	for _, p := range proposals {
+		byType[p.Type] = append(byType[p.Type], p)
+	}
+
+	reports := make([]TypeReport, 0, len(byType))
+	for t, ps := range byType {
+		report := TypeReport{
+			Type:      t,
+			Proposals: len(ps),
+			States:    make(map[string]StateStats),
+		}
+
+		perState := make(map[string][]Duration)
+		pendingToMerged := make([]Duration, 0)
+		for _, p := range ps {
+			for state, d := range p.TimeInStates {
+				perState[state] = append(perState[state], d)
+			}
+			if p.PendingToMerged != nil {
+				pendingToMerged = append(pendingToMerged, *p.PendingToMerged)
+			}
+		}
+
+		for state, ds := range perState {
+			report.States[state] = stateStats(ds)
+		}
+
+		if len(pendingToMerged) > 0 {
+			m := median(pendingToMerged)
+			report.MedianPendingToMerged = &m
+		}
+
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Type < reports[j].Type
+	})
+
+	return reports
+}
+
+// stuckProposals returns at most the given number of open proposals which SCSP
+// state isn't a final one, ordered by the time they've spent in their current
+// state, longest first.
+func stuckProposals(proposals []ProposalReport, limit int) []ProposalReport {
+	stuck := make([]ProposalReport, 0)
+	for _, p := range proposals {
+		if len(p.State) > 0 && !finalStates[p.State] && !p.Closed {
+			stuck = append(stuck, p)
+		}
+	}
+
+	sort.SliceStable(stuck, func(i, j int) bool {
+		return stuck[i].InStateSince.Before(stuck[j].InStateSince)
+	})
+
+	if limit >= 0 && len(stuck) > limit {
+		stuck = stuck[:limit]
+	}
+
+	return stuck
+}
+
+// stateStats computes the statistics of the given non-empty list of times
+// spent in a state.
+func stateStats(ds []Duration) (s StateStats) {
+	s.Proposals = len(ds)
+
+	var total Duration
+	for _, d := range ds {
+		total += d
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+
+	s.Mean = total / Duration(len(ds))
+	s.Median = median(ds)
+	return
+}
+
+// median returns the median of the given non-empty list of durations. The
+// list is sorted in place.
+func median(ds []Duration) Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	mid := len(ds) / 2
+	if len(ds)%2 == 0 {
+		return (ds[mid-1] + ds[mid]) / 2
+	}
+	return ds[mid]
+}
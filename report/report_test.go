@@ -0,0 +1,182 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Informo/specs-bot/types"
+)
+
+var start = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// day returns the time the given number of days after start.
+func day(n int) time.Time {
+	return start.Add(time.Duration(n) * 24 * time.Hour)
+}
+
+// days returns the duration of the given number of days.
+func days(n int) Duration {
+	return Duration(time.Duration(n) * 24 * time.Hour)
+}
+
+// halfDays returns the duration of the given number of half days.
+func halfDays(n int) Duration {
+	return Duration(time.Duration(n) * 12 * time.Hour)
+}
+
+// event returns an event that happened to the given proposal on the given day.
+func event(
+	number int64, n int, event string, action string, typ string, state string,
+) types.ProposalEvent {
+	return types.ProposalEvent{
+		Number:     number,
+		OccurredAt: day(n),
+		Event:      event,
+		Action:     action,
+		Type:       typ,
+		State:      state,
+	}
+}
+
+func TestGenerateProposals(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []types.ProposalEvent
+		expected ProposalReport
+	}{
+		{
+			name: "merged pull request",
+			events: []types.ProposalEvent{
+				event(1, 0, "pull_request", "opened", "", ""),
+				event(1, 1, "pull_request", "labeled", "feature", "pending"),
+				event(1, 3, "pull_request", "labeled", "feature", "fcp"),
+				event(1, 6, "pull_request", "merged", "feature", "fcp"),
+			},
+			expected: ProposalReport{
+				Number:       1,
+				Type:         "feature",
+				State:        "fcp",
+				InStateSince: day(3),
+				Closed:       true,
+				TimeInStates: map[string]Duration{
+					"pending": days(2),
+					"fcp":     days(3),
+				},
+				PendingToMerged: durationPtr(days(5)),
+			},
+		},
+		{
+			name: "issue closed then reopened",
+			events: []types.ProposalEvent{
+				event(2, 0, "issues", "labeled", "behaviour", "review"),
+				event(2, 2, "issues", "closed", "behaviour", "review"),
+				event(2, 5, "issues", "reopened", "behaviour", "review"),
+			},
+			expected: ProposalReport{
+				Number:       2,
+				Type:         "behaviour",
+				State:        "review",
+				InStateSince: day(0),
+				TimeInStates: map[string]Duration{
+					"review": days(7),
+				},
+			},
+		},
+		{
+			name: "closed issue",
+			events: []types.ProposalEvent{
+				event(3, 0, "issues", "labeled", "behaviour", "review"),
+				event(3, 4, "issues", "closed", "behaviour", "review"),
+			},
+			expected: ProposalReport{
+				Number:       3,
+				Type:         "behaviour",
+				State:        "review",
+				InStateSince: day(0),
+				Closed:       true,
+				TimeInStates: map[string]Duration{
+					"review": days(4),
+				},
+			},
+		},
+		{
+			name: "final state",
+			events: []types.ProposalEvent{
+				event(4, 0, "issues", "labeled", "feature", "pending"),
+				event(4, 1, "issues", "labeled", "feature", "won't merge"),
+			},
+			expected: ProposalReport{
+				Number:       4,
+				Type:         "feature",
+				State:        "won't merge",
+				InStateSince: day(1),
+				TimeInStates: map[string]Duration{
+					"pending": days(1),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Generate("Informo/specs", tt.events, day(10), 10)
+			if len(r.Proposals) != 1 {
+				t.Fatalf("got %d proposals, expected 1", len(r.Proposals))
+			}
+
+			if !reflect.DeepEqual(r.Proposals[0], tt.expected) {
+				t.Errorf("got %+v, expected %+v", r.Proposals[0], tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateAggregates(t *testing.T) {
+	events := []types.ProposalEvent{
+		event(1, 0, "pull_request", "labeled", "feature", "pending"),
+		event(1, 2, "pull_request", "merged", "feature", "pending"),
+		event(2, 1, "pull_request", "labeled", "feature", "pending"),
+		event(2, 5, "pull_request", "merged", "feature", "pending"),
+		event(3, 4, "issues", "labeled", "feature", "review"),
+		event(4, 6, "issues", "labeled", "fix", "review"),
+		event(5, 2, "issues", "labeled", "fix", "review"),
+		event(5, 3, "issues", "closed", "fix", "review"),
+	}
+
+	r := Generate("Informo/specs", events, day(10), 1)
+
+	expectedTypes := []TypeReport{
+		{
+			Type:      "feature",
+			Proposals: 3,
+			States: map[string]StateStats{
+				"pending": {Proposals: 2, Mean: days(3), Median: days(3), Max: days(4)},
+				"review":  {Proposals: 1, Mean: days(6), Median: days(6), Max: days(6)},
+			},
+			MedianPendingToMerged: durationPtr(days(3)),
+		},
+		{
+			Type:      "fix",
+			Proposals: 2,
+			States: map[string]StateStats{
+				"review": {Proposals: 2, Mean: halfDays(5), Median: halfDays(5), Max: days(4)},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(r.Types, expectedTypes) {
+		t.Errorf("got types %+v, expected %+v", r.Types, expectedTypes)
+	}
+
+	// Proposals 1 and 2 are closed, and proposal 3 has been in its state for
+	// longer than proposal 4.
+	if len(r.Stuck) != 1 || r.Stuck[0].Number != 3 {
+		t.Errorf("got stuck proposals %+v, expected proposal 3", r.Stuck)
+	}
+}
+
+// durationPtr returns a pointer to the given duration.
+func durationPtr(d Duration) *Duration {
+	return &d
+}
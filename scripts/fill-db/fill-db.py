@@ -32,11 +32,8 @@ def main():
     conn = sqlite3.connect(DB_PATH)
     c = conn.cursor()
 
-    # Create "proposal_state table if it does not exist"
-    c.execute("""CREATE TABLE IF NOT EXISTS proposal_state (
-        number INTEGER PRIMARY KEY,
-        labels TEXT NOT NULL
-    )""")
+    # The "proposal_labels" table is created by the bot's migrations, which
+    # must have been applied beforehand (e.g. with "specs-bot migrate").
 
     # Get repo labels and map to our user-defined label names
     print("Downloading labels for %s" % REPO)
@@ -48,16 +45,13 @@ def main():
     # Get PRs with special label
     print("Downloading issues and PRs with labels: %s" % str([label.name for label in label_objects]))
     for issue in repo.get_issues(labels=label_objects):
-        labels_str = ""
-        for label in issue.labels:
-            labels_str += label.name + ","
+        labels = [label.name for label in issue.labels]
 
-        # Remove final comma
-        labels_str = labels_str[:-1]
+        print("Inserting %s:%s" % (issue.number, ",".join(labels)))
 
-        print("Inserting %s:%s" % (issue.number, labels_str))
-
-        c.execute("INSERT OR REPLACE INTO proposal_state (number, labels) VALUES (?, ?)", (issue.number, labels_str))
+        c.execute("DELETE FROM proposal_labels WHERE repository = ? AND number = ?", (repo.full_name, issue.number))
+        for ordinal, label in enumerate(labels):
+            c.execute("INSERT INTO proposal_labels (repository, number, label, ordinal) VALUES (?, ?, ?, ?)", (repo.full_name, issue.number, label, ordinal))
 
     # Commit changes and close DB connection
     conn.commit()
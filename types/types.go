@@ -1,14 +1,54 @@
 package types
 
+import (
+	"time"
+)
+
 // SCSData is a representation of a SCS, and is filled from both the data
 // located in the SCS's PR and the strings located in the strings JSON file.
+// MessageKeys lists the locations in the strings JSON file where Message can be
+// found, ordered by preference, so that it can be looked up again in another
+// strings file. Actor is the login of the GitHub user who triggered the update,
+// if known, and Author the login of the GitHub user who opened the SCS.
+// Repository is the full name of the repository the SCS belongs to. Draft
+// tells whether the SCS is a draft pull request, and Closed whether it's
+// closed.
 type SCSData struct {
-	Number  int64
-	Title   string
-	Type    string
-	State   string
-	Message string
-	URL     string
+	Repository  string
+	Number      int64
+	Title       string
+	Type        string
+	State       string
+	Message     string
+	MessageKeys []MessageKey
+	URL         string
+	Labels      []string
+	Actor       string
+	Author      string
+	Draft       bool
+	Closed      bool
+}
+
+// NoticeEvent is an update to a SCS, which the notice rules are matched
+// against. Event is the name of the GitHub event and Action the action it
+// describes, the same way as in ProposalEvent. Added and Removed are the names
+// of the label added or removed by the event, if any. PreviousState is the
+// SCS's SCSP state before the update, which is only known if HasPreviousState
+// is true.
+type NoticeEvent struct {
+	Event            string
+	Action           string
+	Added            string
+	Removed          string
+	PreviousState    string
+	HasPreviousState bool
+}
+
+// MessageKey is the location of a message string in a strings JSON file, i.e.
+// the section it's in and its name in this section.
+type MessageKey struct {
+	Section string
+	Name    string
 }
 
 // CopyWithMsg returns a new instance of SCSData with the given string as its
@@ -16,13 +56,121 @@ type SCSData struct {
 func (d *SCSData) CopyWithMsg(msg string) *SCSData {
 	newData := new(SCSData)
 
+	newData.Repository = d.Repository
 	newData.Number = d.Number
 	newData.Title = d.Title
 	newData.Type = d.Type
 	newData.State = d.State
 	newData.URL = d.URL
+	newData.Labels = d.Labels
+	newData.MessageKeys = d.MessageKeys
+	newData.Actor = d.Actor
+	newData.Author = d.Author
+	newData.Draft = d.Draft
+	newData.Closed = d.Closed
 
 	newData.Message = msg
 
 	return newData
 }
+
+// CommentData is a representation of a comment on a SCS, filled from the data
+// located in the comment's payload. DeliveryID is the GUID of the webhook
+// delivery the comment comes from, if known.
+type CommentData struct {
+	Repository        string
+	Number            int64
+	Title             string
+	URL               string
+	Labels            []string
+	Author            string
+	AuthorAssociation string
+	Body              string
+	CommentURL        string
+	DeliveryID        string
+}
+
+// ProposalEvent is an event that happened to a proposal, as recorded in the
+// proposal's history. Event is the name of the GitHub event and Action the
+// action it describes, except for pull requests being closed, which action is
+// either "merged" or "closed", and for reviews, which action is the review's
+// state (e.g. "approved"). Type and State are the proposal's type and SCSP
+// state after the event, if known. NoticeSent tells whether a notice was sent
+// to at least one Matrix room for this event. DeliveryID is the GUID of the
+// webhook delivery the event comes from, if known.
+type ProposalEvent struct {
+	Repository    string
+	Number        int64
+	OccurredAt    time.Time
+	Actor         string
+	Event         string
+	Action        string
+	LabelsAdded   []string
+	LabelsRemoved []string
+	Type          string
+	State         string
+	NoticeSent    bool
+	DeliveryID    string
+}
+
+// LabelConflict is a conflict between labels of a proposal, i.e. more than one
+// label defining the proposal's type or SCSP state. Kind is the kind of
+// information defined by the conflicting labels ("type" or "state"), Labels
+// lists the conflicting labels' names, and Resolution is the name of the label
+// picked to resolve the conflict, if any. Actor is the login of the GitHub user who
+// triggered the event that revealed the conflict.
+type LabelConflict struct {
+	Repository string
+	Number     int64
+	OccurredAt time.Time
+	Actor      string
+	Kind       string
+	Labels     []string
+	Resolution string
+}
+
+// TransitionData is a representation of a transition of a SCS from a SCSP
+// state to another, used to report transitions that aren't allowed. Actor is
+// the login of the GitHub user who triggered the transition, if known.
+type TransitionData struct {
+	Repository string
+	Number     int64
+	Title      string
+	URL        string
+	Type       string
+	From       string
+	To         string
+	Actor      string
+}
+
+// Reminder is a notice to send once a proposal has stayed in a given SCSP state
+// for a configured amount of time, i.e. when DueAt has elapsed.
+type Reminder struct {
+	Repository string
+	Number     int64
+	Type       string
+	State      string
+	Title      string
+	URL        string
+	DueAt      time.Time
+}
+
+// Job is a webhook payload queued for processing.
+type Job struct {
+	ID         string
+	DeliveryID string
+	Event      string
+	Payload    []byte
+	Attempts   int
+}
+
+// Contains checks whether the given slice contains the given string.
+func Contains(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}